@@ -0,0 +1,96 @@
+package watchdir
+
+import (
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// DefaultAdaptiveMinInterval is the interval an AdaptiveInterval uses after activity when its Min isn't positive.
+const DefaultAdaptiveMinInterval = time.Second
+
+// Scheduler decides how long to wait between consecutive sweeps.
+type Scheduler interface {
+	// Next returns the time to wait before the next sweep, given the number of events emitted by the sweep that just
+	// completed.
+	Next(events int) time.Duration
+}
+
+// fixedInterval is a Scheduler that always waits the same amount of time.
+type fixedInterval time.Duration
+
+func (i fixedInterval) Next(int) time.Duration {
+	return time.Duration(i)
+}
+
+// AdaptiveInterval is a Scheduler that sweeps frequently while changes are being observed, and backs off toward a
+// maximum interval during quiet periods. It must not be copied after first use.
+type AdaptiveInterval struct {
+	// Min is the interval used immediately after a sweep that emitted events. Defaults to DefaultAdaptiveMinInterval.
+	Min time.Duration
+
+	// Max is the upper bound the interval backs off to while sweeps are quiet. Values below Min default to Min, which
+	// keeps the interval fixed.
+	Max time.Duration
+
+	// Backoff is the factor the interval grows by after each quiet sweep. Values below 1 default to 2.
+	Backoff float64
+
+	// Jitter is the fraction of the interval that is randomly added or subtracted, so that many watchers started at the
+	// same time don't sweep in lockstep. For example, 0.1 varies each interval by up to ±10%. It is applied after the
+	// Min and Max bounds.
+	Jitter float64
+
+	// Logger, if not nil, receives a line each time the interval changes.
+	Logger *log.Logger
+
+	mu       sync.Mutex
+	interval time.Duration
+	last     time.Duration
+}
+
+// Next implements Scheduler.
+func (a *AdaptiveInterval) Next(events int) time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	minInterval := a.Min
+	if minInterval <= 0 {
+		minInterval = DefaultAdaptiveMinInterval
+	}
+	maxInterval := max(a.Max, minInterval)
+
+	// Reset to the minimum when there is activity, otherwise back off toward the maximum
+	prev := a.interval
+	if events > 0 || a.interval < minInterval {
+		a.interval = minInterval
+	} else {
+		backoff := a.Backoff
+		if backoff < 1 {
+			backoff = 2
+		}
+		a.interval = time.Duration(float64(a.interval) * backoff)
+	}
+	if a.interval > maxInterval {
+		a.interval = maxInterval
+	}
+	if a.interval != prev && a.Logger != nil {
+		a.Logger.Printf("sweep interval changed to %s", a.interval)
+	}
+
+	// Apply the jitter to the chosen interval, which can't make it negative
+	a.last = a.interval
+	if a.Jitter > 0 {
+		a.last += time.Duration((rand.Float64()*2 - 1) * a.Jitter * float64(a.interval))
+		a.last = max(a.last, 0)
+	}
+	return a.last
+}
+
+// Current returns the most recent interval returned by Next, including jitter.
+func (a *AdaptiveInterval) Current() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.last
+}
@@ -0,0 +1,141 @@
+package watchdir_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/spiretechnology/go-memfs"
+	"github.com/spiretechnology/go-watchdir/v2"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingScheduler struct {
+	counts []int
+	cancel context.CancelFunc
+}
+
+func (s *recordingScheduler) Next(events int) time.Duration {
+	s.counts = append(s.counts, events)
+	if len(s.counts) == 2 {
+		s.cancel()
+	}
+	return 0
+}
+
+func TestAdaptiveInterval(t *testing.T) {
+	t.Run("backs off while quiet and resets on activity", func(t *testing.T) {
+		a := &watchdir.AdaptiveInterval{
+			Min: time.Second,
+			Max: 5 * time.Second,
+		}
+		require.Equal(t, time.Second, a.Next(3), "wrong interval after activity")
+		require.Equal(t, 2*time.Second, a.Next(0), "wrong interval after first quiet sweep")
+		require.Equal(t, 4*time.Second, a.Next(0), "wrong interval after second quiet sweep")
+		require.Equal(t, 5*time.Second, a.Next(0), "interval should be capped at max")
+		require.Equal(t, 5*time.Second, a.Next(0), "interval should stay at max")
+		require.Equal(t, time.Second, a.Next(1), "interval should reset after activity")
+		require.Equal(t, time.Second, a.Current(), "wrong current interval")
+	})
+	t.Run("applies jitter around the interval", func(t *testing.T) {
+		a := &watchdir.AdaptiveInterval{
+			Min:    10 * time.Second,
+			Max:    10 * time.Second,
+			Jitter: 0.1,
+		}
+		for range 100 {
+			interval := a.Next(0)
+			require.GreaterOrEqual(t, interval, 9*time.Second, "jitter too large")
+			require.LessOrEqual(t, interval, 11*time.Second, "jitter too large")
+		}
+	})
+	t.Run("defaults unset bounds", func(t *testing.T) {
+		var zero watchdir.AdaptiveInterval
+		require.Equal(t, watchdir.DefaultAdaptiveMinInterval, zero.Next(0), "zero value should use the default minimum")
+
+		a := &watchdir.AdaptiveInterval{Min: time.Second}
+		for range 3 {
+			require.Equal(t, time.Second, a.Next(0), "missing max should keep the interval at min")
+		}
+	})
+	t.Run("never returns a negative interval", func(t *testing.T) {
+		a := &watchdir.AdaptiveInterval{Min: time.Second, Max: time.Second, Jitter: 5}
+		for range 100 {
+			require.GreaterOrEqual(t, a.Next(0), time.Duration(0), "jitter should not make the interval negative")
+		}
+	})
+	t.Run("scheduler receives event counts", func(t *testing.T) {
+		fsys := memfs.FS{
+			"foo": memfs.File("hello"),
+			"bar": memfs.File("world"),
+		}
+		wd := watchdir.New(fsys, watchdir.WithWriteStabilityThreshold(0))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		scheduler := &recordingScheduler{cancel: cancel}
+
+		chanEvents := make(chan watchdir.Event, 10)
+		err := watchdir.WatchWithScheduler(ctx, wd, scheduler, chanEvents)
+		require.ErrorIs(t, err, context.Canceled)
+		require.Equal(t, []int{2, 0}, scheduler.counts, "wrong event counts")
+	})
+	t.Run("delivers every committed event when cancelled", func(t *testing.T) {
+		for range 20 {
+			fsys := memfs.FS{}
+			for i := range 10 {
+				fsys[fmt.Sprintf("file%d", i)] = memfs.File("")
+			}
+			wd := watchdir.NewDirWatcher(fsys, watchdir.WithWriteStabilityThreshold(0))
+
+			// Cancel after the first event, but keep reading until the watcher stops
+			ctx, cancel := context.WithCancel(context.Background())
+			chanEvents := make(chan watchdir.Event)
+			chanErr := make(chan error, 1)
+			go func() {
+				defer close(chanEvents)
+				chanErr <- watchdir.WatchWithScheduler(ctx, wd, &watchdir.AdaptiveInterval{Min: time.Hour}, chanEvents)
+			}()
+			var received int
+			for range chanEvents {
+				received++
+				cancel()
+			}
+			cancel()
+			require.ErrorIs(t, <-chanErr, context.Canceled, "watcher should stop when cancelled")
+
+			// Every file that is known to the watcher must have been delivered, or it is never reported
+			snap, err := wd.Snapshot(context.Background())
+			require.NoError(t, err, "error taking snapshot")
+			require.Equal(t, snap.Len(), received, "committed events should be delivered")
+		}
+	})
+	t.Run("stops forwarding events when cancelled", func(t *testing.T) {
+		fsys := memfs.FS{}
+		for i := range 10 {
+			fsys[fmt.Sprintf("file%d", i)] = memfs.File("")
+		}
+
+		// Watchers that don't count their own events have them forwarded
+		wd := watchdir.NewDirWatcher(fsys, watchdir.WithWriteStabilityThreshold(0))
+		w := struct{ watchdir.Watcher }{wd}
+
+		// Stop reading after the first event, then cancel. The watcher must not wait for the events it can't send.
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		chanEvents := make(chan watchdir.Event)
+		chanErr := make(chan error, 1)
+		go func() {
+			chanErr <- watchdir.WatchWithScheduler(ctx, w, &watchdir.AdaptiveInterval{Min: time.Hour}, chanEvents)
+		}()
+		<-chanEvents
+		cancel()
+		select {
+		case err := <-chanErr:
+			require.ErrorIs(t, err, context.Canceled, "watcher should stop when cancelled")
+		case <-time.After(5 * time.Second):
+			t.Fatal("watcher should stop when cancelled, even if events aren't read")
+		}
+	})
+}
@@ -0,0 +1,230 @@
+package watchdir
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SlowSubscriberPolicy determines what a broker does when a subscriber's buffer is full.
+type SlowSubscriberPolicy uint8
+
+const (
+	// SlowSubscriberBlock waits for the subscriber to make room, which also holds up the sweep and every other
+	// subscriber. This matches the behavior of reading from Watch directly.
+	SlowSubscriberBlock SlowSubscriberPolicy = iota
+
+	// SlowSubscriberDrop discards the event for that subscriber only, and counts it in Subscription.Dropped.
+	SlowSubscriberDrop
+
+	// SlowSubscriberDisconnect closes the subscriber's channel, so it can resubscribe and rescan to catch up.
+	SlowSubscriberDisconnect
+)
+
+// DefaultBrokerInterval is the default time to wait between sweeps of a broker's watcher.
+const DefaultBrokerInterval = 5 * time.Second
+
+// BrokerConfig configures a Broker.
+type BrokerConfig struct {
+	// Watcher is swept for events, which are sent to every subscriber.
+	Watcher Watcher
+
+	// Interval is the time to wait between sweeps. Defaults to DefaultBrokerInterval.
+	Interval time.Duration
+
+	// Scheduler, if not nil, chooses the time to wait between sweeps instead of Interval.
+	Scheduler Scheduler
+
+	// Policy determines what happens when a subscriber's buffer is full. Defaults to SlowSubscriberBlock.
+	Policy SlowSubscriberPolicy
+
+	// Logger, if not nil, receives a line for each subscriber that is disconnected, and each filter that fails.
+	Logger *log.Logger
+}
+
+// Broker runs a single watcher and sends its events to any number of subscribers, each with its own filter and buffer.
+type Broker struct {
+	cfg BrokerConfig
+
+	mu          sync.Mutex
+	subscribers map[*Subscription]struct{}
+	stopped     bool
+}
+
+// Subscription receives the events from a broker that pass its filter.
+type Subscription struct {
+	broker  *Broker
+	filter  Filter
+	dropped atomic.Uint64
+
+	// The events channel is only closed while holding mu, which is also held while sending, so that closing can't race
+	// with a send. Closing done first wakes any send that is blocked waiting for room.
+	mu        sync.Mutex
+	events    chan Event
+	done      chan struct{}
+	closeOnce sync.Once
+	closed    bool
+}
+
+// NewBroker creates a broker. Events are sent to subscribers once Run is called.
+func NewBroker(cfg BrokerConfig) (*Broker, error) {
+	if cfg.Watcher == nil {
+		return nil, errors.New("broker watcher is required")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultBrokerInterval
+	}
+	if cfg.Scheduler == nil {
+		cfg.Scheduler = fixedInterval(cfg.Interval)
+	}
+	return &Broker{
+		cfg:         cfg,
+		subscribers: make(map[*Subscription]struct{}),
+	}, nil
+}
+
+// Subscribe registers a subscriber that receives the events whose paths pass the filter, or every event if the filter
+// is nil. Up to bufferSize events are held for the subscriber before the broker's slow-subscriber policy applies.
+func (b *Broker) Subscribe(filter Filter, bufferSize int) *Subscription {
+	sub := &Subscription{
+		broker: b,
+		filter: filter,
+		events: make(chan Event, max(bufferSize, 0)),
+		done:   make(chan struct{}),
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stopped {
+		sub.close()
+		return sub
+	}
+	b.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// Run sweeps the watcher and sends events to the subscribers until the context is cancelled. When it returns, every
+// subscriber's channel is closed.
+func (b *Broker) Run(ctx context.Context) error {
+	defer b.stop()
+
+	chanEvents := make(chan Event)
+	chanDone := make(chan error, 1)
+	go func() {
+		defer close(chanEvents)
+		chanDone <- WatchWithScheduler(ctx, b.cfg.Watcher, b.cfg.Scheduler, chanEvents)
+	}()
+	for event := range chanEvents {
+		b.publish(ctx, event)
+	}
+	return <-chanDone
+}
+
+// publish sends an event to every subscriber whose filter it passes.
+func (b *Broker) publish(ctx context.Context, event Event) {
+	b.mu.Lock()
+	subscribers := make([]*Subscription, 0, len(b.subscribers))
+	for sub := range b.subscribers {
+		subscribers = append(subscribers, sub)
+	}
+	b.mu.Unlock()
+
+	for _, sub := range subscribers {
+		if sub.filter != nil {
+			include, err := sub.filter.Filter(ctx, event.File)
+			if err != nil {
+				b.logf("filter %q: %v", event.File, err)
+				continue
+			}
+			if !include {
+				continue
+			}
+		}
+		if !sub.send(ctx, event, b.cfg.Policy) {
+			b.logf("disconnected slow subscriber")
+			b.unsubscribe(sub)
+		}
+	}
+}
+
+// stop closes every subscription, and any that are created afterwards.
+func (b *Broker) stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stopped = true
+	for sub := range b.subscribers {
+		delete(b.subscribers, sub)
+		sub.close()
+	}
+}
+
+func (b *Broker) unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	delete(b.subscribers, sub)
+	b.mu.Unlock()
+	sub.close()
+}
+
+func (b *Broker) logf(format string, args ...any) {
+	if b.cfg.Logger != nil {
+		b.cfg.Logger.Printf(format, args...)
+	}
+}
+
+// Events returns the channel that receives the subscriber's events. It is closed when the subscription is closed, when
+// the subscriber is disconnected for falling behind, or when the broker stops.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped returns the number of events discarded because the subscriber's buffer was full.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close stops sending events to the subscriber, and closes its channel.
+func (s *Subscription) Close() {
+	s.broker.unsubscribe(s)
+}
+
+// send delivers an event according to the policy. It returns false if the subscriber should be disconnected.
+func (s *Subscription) send(ctx context.Context, event Event, policy SlowSubscriberPolicy) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return true
+	}
+
+	select {
+	case s.events <- event:
+		return true
+	default:
+	}
+	switch policy {
+	case SlowSubscriberDrop:
+		s.dropped.Add(1)
+		return true
+	case SlowSubscriberDisconnect:
+		return false
+	default:
+		select {
+		case s.events <- event:
+		case <-s.done:
+		case <-ctx.Done():
+		}
+		return true
+	}
+}
+
+func (s *Subscription) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.closed = true
+		close(s.events)
+	})
+}
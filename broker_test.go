@@ -0,0 +1,111 @@
+package watchdir_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spiretechnology/go-watchdir/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// receiveFiles waits for a number of events from a subscription, and returns their paths.
+func receiveFiles(t *testing.T, sub *watchdir.Subscription, count int) []string {
+	var files []string
+	for range count {
+		select {
+		case event, ok := <-sub.Events():
+			require.True(t, ok, "subscription closed early")
+			files = append(files, event.File)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for an event")
+		}
+	}
+	return files
+}
+
+// runBroker runs a broker in the background, and returns a function that stops it.
+func runBroker(t *testing.T, dir string, policy watchdir.SlowSubscriberPolicy) (*watchdir.Broker, func()) {
+	broker, err := watchdir.NewBroker(watchdir.BrokerConfig{
+		Watcher:  watchdir.New(os.DirFS(dir), watchdir.WithWriteStabilityThreshold(0)),
+		Interval: 10 * time.Millisecond,
+		Policy:   policy,
+	})
+	require.NoError(t, err, "error creating broker")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	chanErr := make(chan error, 1)
+	return broker, func() {
+		go func() {
+			chanErr <- broker.Run(ctx)
+		}()
+		t.Cleanup(func() {
+			cancel()
+			require.ErrorIs(t, <-chanErr, context.Canceled, "broker should stop when cancelled")
+		})
+	}
+}
+
+func TestBroker(t *testing.T) {
+	t.Run("sends events to each matching subscriber", func(t *testing.T) {
+		dir := t.TempDir()
+		for _, name := range []string{"a/1", "a/2", "b/1"} {
+			writeFile(t, filepath.Join(dir, name), "")
+		}
+		broker, start := runBroker(t, dir, watchdir.SlowSubscriberBlock)
+		all := broker.Subscribe(nil, 10)
+		onlyA := broker.Subscribe(watchdir.FilterFunc(func(ctx context.Context, file string) (bool, error) {
+			return strings.HasPrefix(file, "a/"), nil
+		}), 10)
+		start()
+
+		require.ElementsMatch(t, []string{"a/1", "a/2", "b/1"}, receiveFiles(t, all, 3), "wrong events for all files")
+		require.ElementsMatch(t, []string{"a/1", "a/2"}, receiveFiles(t, onlyA, 2), "wrong events for filtered files")
+
+		// A closed subscription no longer receives events
+		onlyA.Close()
+		writeFile(t, filepath.Join(dir, "a", "3"), "")
+		require.Equal(t, []string{"a/3"}, receiveFiles(t, all, 1), "wrong event after close")
+		_, ok := <-onlyA.Events()
+		require.False(t, ok, "closed subscription should have a closed channel")
+	})
+	t.Run("drops events for slow subscribers", func(t *testing.T) {
+		dir := t.TempDir()
+		for _, name := range []string{"foo", "bar", "baz"} {
+			writeFile(t, filepath.Join(dir, name), "")
+		}
+		broker, start := runBroker(t, dir, watchdir.SlowSubscriberDrop)
+		slow := broker.Subscribe(nil, 1)
+		fast := broker.Subscribe(nil, 10)
+		start()
+
+		// The fast subscriber isn't held up, and the slow one keeps what fits in its buffer
+		require.Len(t, receiveFiles(t, fast, 3), 3, "fast subscriber should receive every event")
+		require.Eventually(t, func() bool {
+			return slow.Dropped() == 2
+		}, 5*time.Second, 10*time.Millisecond, "slow subscriber should drop the events that don't fit")
+		require.Len(t, receiveFiles(t, slow, 1), 1, "slow subscriber should keep a buffered event")
+	})
+	t.Run("disconnects slow subscribers", func(t *testing.T) {
+		dir := t.TempDir()
+		for _, name := range []string{"foo", "bar", "baz"} {
+			writeFile(t, filepath.Join(dir, name), "")
+		}
+		broker, start := runBroker(t, dir, watchdir.SlowSubscriberDisconnect)
+		slow := broker.Subscribe(nil, 1)
+		fast := broker.Subscribe(nil, 10)
+		start()
+
+		require.Len(t, receiveFiles(t, fast, 3), 3, "fast subscriber should receive every event")
+		require.Len(t, receiveFiles(t, slow, 1), 1, "slow subscriber should keep a buffered event")
+		select {
+		case _, ok := <-slow.Events():
+			require.False(t, ok, "slow subscriber should be disconnected")
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the subscriber to be disconnected")
+		}
+	})
+}
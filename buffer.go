@@ -0,0 +1,196 @@
+package watchdir
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strings"
+	"sync/atomic"
+)
+
+// OverflowPolicy determines what an EventBuffer does with new events when it is full.
+type OverflowPolicy uint8
+
+const (
+	// OverflowBlock stops reading new events until there is room, which holds up the sweep.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDropNewest discards new events until there is room.
+	OverflowDropNewest
+
+	// OverflowDropOldest discards the oldest buffered event to make room for each new one.
+	OverflowDropOldest
+
+	// OverflowSpill appends new events to a log on disk until there is room, so that none are lost.
+	OverflowSpill
+)
+
+// DefaultBufferSize is the default number of events an EventBuffer holds in memory.
+const DefaultBufferSize = 1000
+
+// BufferConfig configures an EventBuffer.
+type BufferConfig struct {
+	// Size is the number of events held in memory. Defaults to DefaultBufferSize.
+	Size int
+
+	// Policy determines what happens to new events when the buffer is full. Defaults to OverflowBlock.
+	Policy OverflowPolicy
+
+	// SpillDir is the directory that events are written to when the buffer is full. It is required by OverflowSpill,
+	// and events left in it by a previous run are delivered before any new ones.
+	SpillDir string
+}
+
+// EventBuffer sits between a watcher and a slow consumer, so that sweeps aren't held up while the consumer catches up.
+//
+// When events are dropped, an Overflow event is sent ahead of the buffered events, telling the consumer which
+// directory to rescan. Files that were removed in the meantime are not reported again by a rescan, so a consumer that
+// needs every removal should spill to disk instead of dropping events.
+type EventBuffer struct {
+	cfg     BufferConfig
+	dropped atomic.Uint64
+	spilled atomic.Uint64
+}
+
+// NewEventBuffer creates an event buffer.
+func NewEventBuffer(cfg BufferConfig) (*EventBuffer, error) {
+	if cfg.Size <= 0 {
+		cfg.Size = DefaultBufferSize
+	}
+	if cfg.Policy == OverflowSpill && cfg.SpillDir == "" {
+		return nil, errors.New("spill directory is required")
+	}
+	return &EventBuffer{cfg: cfg}, nil
+}
+
+// Dropped returns the number of events that were discarded because the buffer was full.
+func (b *EventBuffer) Dropped() uint64 {
+	return b.dropped.Load()
+}
+
+// Spilled returns the number of events that were written to disk because the buffer was full.
+func (b *EventBuffer) Spilled() uint64 {
+	return b.spilled.Load()
+}
+
+// Run reads events from in and forwards them to out, buffering them while out isn't ready. When in is closed, the
+// remaining events are forwarded and Run returns nil. When the context is cancelled, the events held in memory are
+// lost, but spilled events that haven't been forwarded are kept on disk for the next run.
+func (b *EventBuffer) Run(ctx context.Context, in <-chan Event, out chan<- Event) error {
+	var spill *spillLog
+	if b.cfg.Policy == OverflowSpill {
+		var err error
+		if spill, err = openSpillLog(b.cfg.SpillDir); err != nil {
+			return err
+		}
+		defer spill.Close()
+	}
+
+	var queue []bufferedEvent
+	var overflow *Event
+	for {
+		// Move spilled events back into memory as room becomes available. They stay on disk until they are forwarded.
+		for spill != nil && spill.Len() > 0 && len(queue) < b.cfg.Size {
+			event, err := spill.Pop()
+			if err != nil {
+				return err
+			}
+			queue = append(queue, bufferedEvent{event: event, spilled: true})
+		}
+		if in == nil && len(queue) == 0 && overflow == nil {
+			return nil
+		}
+
+		// Send the overflow event first, so the consumer can start its rescan as early as possible
+		var next Event
+		var chanOut chan<- Event
+		switch {
+		case overflow != nil:
+			next, chanOut = *overflow, out
+		case len(queue) > 0:
+			next, chanOut = queue[0].event, out
+		}
+
+		// Only accept new events while there is room, unless the policy makes room
+		chanIn := in
+		if b.cfg.Policy == OverflowBlock && len(queue) >= b.cfg.Size {
+			chanIn = nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-chanIn:
+			if !ok {
+				in = nil // Channel closed
+				continue
+			}
+			var dropped *Event
+			switch {
+			case spill != nil && (spill.Len() > 0 || len(queue) >= b.cfg.Size):
+				// Once events are spilled, new events are spilled behind them to keep them in order
+				if err := spill.Push(event); err != nil {
+					return err
+				}
+				b.spilled.Add(1)
+			case len(queue) < b.cfg.Size:
+				queue = append(queue, bufferedEvent{event: event})
+			case b.cfg.Policy == OverflowDropOldest:
+				oldest := queue[0].event
+				dropped = &oldest
+				queue = append(queue[1:], bufferedEvent{event: event})
+			default:
+				dropped = &event
+			}
+			if dropped != nil {
+				b.dropped.Add(1)
+				overflow = addOverflow(overflow, *dropped)
+			}
+		case chanOut <- next:
+			if overflow != nil {
+				overflow = nil
+				continue
+			}
+			if queue[0].spilled {
+				if err := spill.Ack(); err != nil {
+					return err
+				}
+			}
+			queue = queue[1:]
+		}
+	}
+}
+
+// bufferedEvent is an event held in memory by an EventBuffer. Events read back from the spill log are kept on disk
+// until they are forwarded, so they aren't lost if the buffer stops first.
+type bufferedEvent struct {
+	event   Event
+	spilled bool
+}
+
+// addOverflow widens an overflow event to cover the directory of a dropped event.
+func addOverflow(overflow *Event, dropped Event) *Event {
+	dir := path.Dir(dropped.File)
+	if overflow == nil {
+		return &Event{Type: Overflow, File: dir, Sweep: dropped.Sweep}
+	}
+	overflow.File = commonDir(overflow.File, dir)
+	overflow.Sweep = max(overflow.Sweep, dropped.Sweep)
+	return overflow
+}
+
+// commonDir returns the deepest directory that contains both directories, or "." if they only share the root.
+func commonDir(a, b string) string {
+	if a == "." || b == "." {
+		return "."
+	}
+	partsA, partsB := strings.Split(a, "/"), strings.Split(b, "/")
+	var common []string
+	for i := 0; i < len(partsA) && i < len(partsB) && partsA[i] == partsB[i]; i++ {
+		common = append(common, partsA[i])
+	}
+	if len(common) == 0 {
+		return "."
+	}
+	return strings.Join(common, "/")
+}
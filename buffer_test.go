@@ -0,0 +1,134 @@
+package watchdir_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/spiretechnology/go-watchdir/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// fillBuffer runs a buffer in the background and sends it events for the given files, without reading any of them.
+func fillBuffer(ctx context.Context, t *testing.T, buffer *watchdir.EventBuffer, files ...string) (chan<- watchdir.Event, <-chan watchdir.Event, <-chan error) {
+	in := make(chan watchdir.Event)
+	out := make(chan watchdir.Event)
+	chanErr := make(chan error, 1)
+	go func() {
+		chanErr <- buffer.Run(ctx, in, out)
+	}()
+	for _, file := range files {
+		select {
+		case in <- watchdir.Event{Type: watchdir.FileAdded, File: file}:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out sending an event")
+		}
+	}
+	return in, out, chanErr
+}
+
+// readBuffer closes the buffer's input, and returns every event it forwards.
+func readBuffer(t *testing.T, in chan<- watchdir.Event, out <-chan watchdir.Event, chanErr <-chan error) []watchdir.Event {
+	close(in)
+	var events []watchdir.Event
+	for {
+		select {
+		case event := <-out:
+			events = append(events, event)
+		case err := <-chanErr:
+			require.NoError(t, err, "error running buffer")
+			return events
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out reading events")
+		}
+	}
+}
+
+func TestEventBuffer(t *testing.T) {
+	t.Run("drops the newest events when full", func(t *testing.T) {
+		buffer, err := watchdir.NewEventBuffer(watchdir.BufferConfig{Size: 2, Policy: watchdir.OverflowDropNewest})
+		require.NoError(t, err, "error creating buffer")
+		in, out, chanErr := fillBuffer(context.Background(), t, buffer, "a/1", "a/2", "b/c/3", "b/4")
+
+		// The overflow event comes first, covering the directories of the dropped events
+		require.Equal(t, []watchdir.Event{
+			{Type: watchdir.Overflow, File: "b"},
+			{Type: watchdir.FileAdded, File: "a/1"},
+			{Type: watchdir.FileAdded, File: "a/2"},
+		}, readBuffer(t, in, out, chanErr), "wrong events forwarded")
+		require.EqualValues(t, 2, buffer.Dropped(), "wrong number of dropped events")
+	})
+	t.Run("drops the oldest events when full", func(t *testing.T) {
+		buffer, err := watchdir.NewEventBuffer(watchdir.BufferConfig{Size: 2, Policy: watchdir.OverflowDropOldest})
+		require.NoError(t, err, "error creating buffer")
+		in, out, chanErr := fillBuffer(context.Background(), t, buffer, "a/1", "a/2", "b/c/3", "b/4")
+
+		require.Equal(t, []watchdir.Event{
+			{Type: watchdir.Overflow, File: "a"},
+			{Type: watchdir.FileAdded, File: "b/c/3"},
+			{Type: watchdir.FileAdded, File: "b/4"},
+		}, readBuffer(t, in, out, chanErr), "wrong events forwarded")
+		require.EqualValues(t, 2, buffer.Dropped(), "wrong number of dropped events")
+	})
+	t.Run("spills to disk when full", func(t *testing.T) {
+		dir := t.TempDir()
+		buffer, err := watchdir.NewEventBuffer(watchdir.BufferConfig{Size: 1, Policy: watchdir.OverflowSpill, SpillDir: dir})
+		require.NoError(t, err, "error creating buffer")
+
+		// Stop the buffer with events spilled, which are kept for the next run
+		ctx, cancel := context.WithCancel(context.Background())
+		_, _, chanErr := fillBuffer(ctx, t, buffer, "a", "b", "c", "d", "e")
+		require.Eventually(t, func() bool {
+			return buffer.Spilled() == 4
+		}, 5*time.Second, 10*time.Millisecond, "wrong number of spilled events")
+		cancel()
+		require.ErrorIs(t, <-chanErr, context.Canceled, "buffer should stop when cancelled")
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err, "error reading spill dir")
+		require.Len(t, entries, 1, "spilled events should be appended to one segment")
+
+		// The spilled events are delivered in order, ahead of new ones
+		in, out, chanErr := fillBuffer(context.Background(), t, buffer, "f")
+		var files []string
+		for _, event := range readBuffer(t, in, out, chanErr) {
+			files = append(files, event.File)
+		}
+		require.Equal(t, []string{"b", "c", "d", "e", "f"}, files, "wrong events forwarded")
+		require.Zero(t, buffer.Dropped(), "no events should be dropped")
+		entries, err = os.ReadDir(dir)
+		require.NoError(t, err, "error reading spill dir")
+		require.Empty(t, entries, "segments should be removed once delivered")
+	})
+	t.Run("keeps spilled events until they are forwarded", func(t *testing.T) {
+		dir := t.TempDir()
+		buffer, err := watchdir.NewEventBuffer(watchdir.BufferConfig{Size: 2, Policy: watchdir.OverflowSpill, SpillDir: dir})
+		require.NoError(t, err, "error creating buffer")
+
+		// Read some of the events, so the rest of the spilled ones are read back into memory, then stop the buffer
+		ctx, cancel := context.WithCancel(context.Background())
+		_, out, chanErr := fillBuffer(ctx, t, buffer, "e1", "e2", "e3", "e4", "e5")
+		for _, file := range []string{"e1", "e2", "e3"} {
+			select {
+			case event := <-out:
+				require.Equal(t, file, event.File, "wrong event forwarded")
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out reading events")
+			}
+		}
+		cancel()
+		require.ErrorIs(t, <-chanErr, context.Canceled, "buffer should stop when cancelled")
+
+		// The events that weren't forwarded are delivered by the next run. Others from the same segment may be repeated.
+		in, out, chanErr := fillBuffer(context.Background(), t, buffer)
+		var files []string
+		for _, event := range readBuffer(t, in, out, chanErr) {
+			files = append(files, event.File)
+		}
+		require.Subset(t, files, []string{"e4", "e5"}, "events that weren't forwarded should be delivered")
+		require.Equal(t, []string{"e4", "e5"}, files[len(files)-2:], "events should be delivered in order")
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err, "error reading spill dir")
+		require.Empty(t, entries, "segments should be removed once delivered")
+	})
+}
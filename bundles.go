@@ -0,0 +1,124 @@
+package watchdir
+
+import (
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// BundleRule describes a set of files that must be processed together, such as a shapefile's ".shp", ".shx" and ".dbf"
+// files. The members of a bundle are in the same directory and share a stem, which is their name without the extension.
+type BundleRule struct {
+	// Name identifies the rule in logs.
+	Name string
+
+	// Extensions are the extensions of every member of the bundle, including the leading dot. They are matched without
+	// regard to case.
+	Extensions []string
+
+	// Timeout, if positive, is the time an incomplete bundle can wait for its remaining members before a FileTimeout
+	// event is sent for it.
+	Timeout time.Duration
+}
+
+// bundleKey identifies a bundle within a directory.
+type bundleKey struct {
+	rule *BundleRule
+	stem string
+}
+
+// bundle is the members of a bundle that are ready to be reported, keyed by their extension.
+type bundle struct {
+	rule    *BundleRule
+	stem    string
+	members map[string]fs.DirEntry
+}
+
+// addMember records a file that belongs to a bundle.
+func addMember(bundles map[bundleKey]*bundle, rule *BundleRule, stem string, entry fs.DirEntry) {
+	key := bundleKey{rule: rule, stem: stem}
+	b := bundles[key]
+	if b == nil {
+		b = &bundle{rule: rule, stem: stem, members: make(map[string]fs.DirEntry)}
+		bundles[key] = b
+	}
+	b.members[path.Ext(entry.Name())] = entry
+}
+
+// bundleRule returns the rule that a file belongs to, and the file's stem.
+func (wd *watcher) bundleRule(name string) (*BundleRule, string) {
+	ext := path.Ext(name)
+	for i := range wd.bundles {
+		rule := &wd.bundles[i]
+		if slices.ContainsFunc(rule.Extensions, func(e string) bool { return strings.EqualFold(e, ext) }) {
+			return rule, strings.TrimSuffix(name, ext)
+		}
+	}
+	return nil, ""
+}
+
+// planBundles reports each bundle whose members are all ready as a single BundleReady event, and holds back the rest.
+// Members that were already known to the cache count towards completing a bundle. The members of complete bundles are
+// added back to the entries, so they are recorded in the cache along with the bundle.
+func (wd *watcher) planBundles(cs *ChangeSet, cache *dirCache, bundles map[bundleKey]*bundle, entries map[string]fs.DirEntry, pending map[string]pendingFile, pathPrefix string, silent bool) {
+	for _, b := range bundles {
+		var members []change
+		complete := true
+		for _, ext := range b.rule.Extensions {
+			name, entry := b.member(cache, ext)
+			if entry == nil {
+				complete = false
+				continue
+			}
+			members = append(members, wd.newChange(FileAdded, path.Join(pathPrefix, name), entry, true))
+		}
+
+		// Hold the bundle back while any of its members is open for writing. This is only checked once every member has
+		// been found, since listing the open files is expensive.
+		if complete && !silent && slices.ContainsFunc(members, func(member change) bool { return wd.isOpen(member.rel) }) {
+			complete = false
+		}
+
+		rel := path.Join(pathPrefix, b.stem)
+		if !complete {
+			if timedOut, ok := wd.holdBack(cache, pending, rel, b.rule.Timeout, silent); ok {
+				timedOut.event.Members = memberPaths(members)
+				cs.add(timedOut)
+			}
+			continue
+		}
+		for _, member := range members {
+			entries[path.Base(member.rel)] = member.entry
+		}
+		c := wd.newChange(BundleReady, rel, nil, silent)
+		c.event.Members = memberPaths(members)
+		c.members = members
+		cs.add(c)
+	}
+}
+
+// member returns the member of the bundle with the given extension, either from the files found by this sweep or from
+// the cache.
+func (b *bundle) member(cache *dirCache, ext string) (string, fs.DirEntry) {
+	for memberExt, entry := range b.members {
+		if strings.EqualFold(memberExt, ext) {
+			return entry.Name(), entry
+		}
+	}
+	for name, entry := range cache.entries {
+		if !entry.IsDir() && strings.EqualFold(path.Ext(name), ext) && strings.TrimSuffix(name, path.Ext(name)) == b.stem {
+			return name, entry
+		}
+	}
+	return "", nil
+}
+
+func memberPaths(members []change) []string {
+	paths := make([]string, len(members))
+	for i, member := range members {
+		paths[i] = member.event.File
+	}
+	return paths
+}
@@ -0,0 +1,85 @@
+package watchdir_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/spiretechnology/go-memfs"
+	"github.com/spiretechnology/go-watchdir/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBundles(t *testing.T) {
+	t.Run("reports complete bundles as a single event", func(t *testing.T) {
+		fsys := memfs.FS{
+			"roads.shp":    memfs.File("shapes"),
+			"roads.SHX":    memfs.File("index"),
+			"movie.mkv":    memfs.File("video"),
+			"readme.txt":   memfs.File("hello"),
+			"rivers/a.shp": memfs.File("shapes"),
+		}
+		wd := watchdir.NewDirWatcher(fsys, watchdir.WithWriteStabilityThreshold(0), watchdir.WithBundles(
+			watchdir.BundleRule{Name: "shapefile", Extensions: []string{".shp", ".shx", ".dbf"}},
+			watchdir.BundleRule{Name: "video", Extensions: []string{".mkv", ".srt"}},
+		))
+
+		// Files outside of bundles are reported straight away, and bundle members wait for the rest of the bundle
+		events, err := sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.Equal(t, map[watchdir.EventType][]string{watchdir.FileAdded: {"readme.txt"}}, events, "wrong events")
+
+		// Completing a bundle reports it once, listing every member
+		fsys["roads.dbf"] = memfs.File("attributes")
+		cs, err := wd.Plan(context.Background())
+		require.NoError(t, err, "error planning")
+		require.Equal(t, []watchdir.Event{{
+			Type:    watchdir.BundleReady,
+			File:    "roads",
+			Sweep:   2,
+			Members: []string{"roads.shp", "roads.SHX", "roads.dbf"},
+		}}, cs.Events(), "wrong planned events")
+		require.NoError(t, cs.Commit(), "error committing")
+		events, err = sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.Empty(t, events, "should not report a bundle twice")
+
+		// Members of a reported bundle are removed individually
+		delete(fsys, "roads.SHX")
+		events, err = sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.Equal(t, map[watchdir.EventType][]string{watchdir.FileRemoved: {"roads.SHX"}}, events, "wrong events")
+	})
+	t.Run("reports a timeout for incomplete bundles", func(t *testing.T) {
+		fsys := memfs.FS{
+			"movie.mkv": memfs.File("video"),
+		}
+		wd := watchdir.NewDirWatcher(fsys, watchdir.WithWriteStabilityThreshold(0), watchdir.WithBundles(
+			watchdir.BundleRule{Name: "video", Extensions: []string{".mkv", ".srt"}, Timeout: 50 * time.Millisecond},
+		))
+		events, err := sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.Empty(t, events, "should not report incomplete bundles")
+
+		// The timeout is reported once, listing the members found so far
+		time.Sleep(100 * time.Millisecond)
+		cs, err := wd.Plan(context.Background())
+		require.NoError(t, err, "error planning")
+		require.Equal(t, []watchdir.Event{{
+			Type:    watchdir.FileTimeout,
+			File:    "movie",
+			Sweep:   2,
+			Members: []string{"movie.mkv"},
+		}}, cs.Events(), "wrong planned events")
+		require.NoError(t, cs.Commit(), "error committing")
+		events, err = sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.Empty(t, events, "should only report a timeout once")
+
+		// The bundle is still reported if it is completed late
+		fsys["movie.srt"] = memfs.File("subtitles")
+		events, err = sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.Equal(t, map[watchdir.EventType][]string{watchdir.BundleReady: {"movie"}}, events, "wrong events")
+	})
+}
@@ -0,0 +1,282 @@
+package watchdir
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ErrStaleChangeSet is returned when committing a change set that was already committed or discarded, or that was
+// planned before the watcher's view of the directory last changed.
+var ErrStaleChangeSet = errors.New("change set is stale")
+
+// ChangeSet holds the changes found by Plan, which are not applied to the watcher's view of the directory until they
+// are committed. Until then, the same changes are found again by the next sweep.
+type ChangeSet struct {
+	wd         *watcher
+	generation uint64
+	root       bool
+	done       bool
+
+	// mu guards the changes and updates while the change set is being planned
+	mu      sync.Mutex
+	changes []change
+	updates []dirUpdate
+}
+
+// change is a single added or removed file, a file that timed out waiting to be ready, or a complete bundle along with
+// the changes that add its members.
+type change struct {
+	event    Event
+	rel      string
+	entry    fs.DirEntry
+	reported bool
+	members  []change
+}
+
+// dirUpdate is the new state of a single directory in the cache. If entries is nil, the existing entries and pending
+// files are kept, and the added directories are recorded in the existing entries.
+type dirUpdate struct {
+	cache   *dirCache
+	dir     string
+	entries map[string]fs.DirEntry
+	pending map[string]pendingFile
+	added   map[string]*dirCache
+	removed []string
+}
+
+func (wd *watcher) newChangeSet(root bool) *ChangeSet {
+	return &ChangeSet{wd: wd, generation: wd.generation, root: root}
+}
+
+func (wd *watcher) Plan(ctx context.Context) (*ChangeSet, error) {
+	wd.sweepMu.Lock()
+	defer wd.sweepMu.Unlock()
+
+	// The changes are found the same way as a sweep, so there are none while another instance holds the lease
+	standby, err := wd.standby()
+	if err != nil {
+		return nil, err
+	}
+	if standby {
+		return wd.newChangeSet(false), nil
+	}
+	return wd.planRoot(ctx, wd.silentBaseline && !wd.baselined)
+}
+
+func (cs *ChangeSet) add(c change) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.changes = append(cs.changes, c)
+}
+
+func (cs *ChangeSet) addUpdate(update dirUpdate) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.updates = append(cs.updates, update)
+}
+
+// sort orders the changes by path, so events are sent in a predictable order.
+func (cs *ChangeSet) sort() {
+	slices.SortFunc(cs.changes, func(a, b change) int {
+		return strings.Compare(a.event.File, b.event.File)
+	})
+}
+
+// Events returns the events that a sweep would send for the changes, in order of their paths.
+func (cs *ChangeSet) Events() []Event {
+	var events []Event
+	for _, change := range cs.changes {
+		if change.reported {
+			events = append(events, change.event)
+		}
+	}
+	return events
+}
+
+// Commit applies every change to the watcher's view of the directory, so they are not found again by later sweeps. It
+// returns ErrStaleChangeSet if the view has changed since the change set was planned.
+func (cs *ChangeSet) Commit() error {
+	cs.wd.sweepMu.Lock()
+	defer cs.wd.sweepMu.Unlock()
+	if cs.stale() {
+		return ErrStaleChangeSet
+	}
+	cs.commit()
+	return nil
+}
+
+// CommitPaths applies only the changes to the given files, which are in the same form as event paths. The remaining
+// changes are found again by the next sweep. It returns ErrStaleChangeSet if the view has changed since the change set
+// was planned.
+func (cs *ChangeSet) CommitPaths(paths ...string) error {
+	cs.wd.sweepMu.Lock()
+	defer cs.wd.sweepMu.Unlock()
+	if cs.stale() {
+		return ErrStaleChangeSet
+	}
+
+	include := make(map[string]bool, len(paths))
+	for _, name := range paths {
+		include[normalizePath(name)] = true
+	}
+	var changes []change
+	for _, change := range cs.changes {
+		if include[change.event.File] {
+			changes = append(changes, change)
+		}
+	}
+	cs.commitChanges(changes)
+	return nil
+}
+
+// Discard abandons the change set without applying any of its changes.
+func (cs *ChangeSet) Discard() {
+	cs.wd.sweepMu.Lock()
+	defer cs.wd.sweepMu.Unlock()
+	cs.done = true
+}
+
+// stale returns true if the change set can no longer be committed. It must be called while holding the sweep lock.
+func (cs *ChangeSet) stale() bool {
+	return cs.done || cs.generation != cs.wd.generation
+}
+
+// commit applies the new state of every directory found while planning. It must be called while holding the sweep lock.
+func (cs *ChangeSet) commit() {
+	for _, update := range cs.updates {
+		update.cache.mu.Lock()
+		if update.entries != nil {
+			update.cache.entries = update.entries
+			update.cache.pending = update.pending
+		} else {
+			for name := range update.added {
+				if update.cache.entries[name] == nil {
+					entries := maps.Clone(update.cache.entries)
+					entries[name] = dirEntry(name)
+					update.cache.entries = entries
+				}
+			}
+		}
+		for _, name := range update.removed {
+			delete(update.cache.children, name)
+		}
+		maps.Copy(update.cache.children, update.added)
+		update.cache.mu.Unlock()
+	}
+	if cs.root {
+		cs.wd.baselined = true
+	}
+	cs.finish()
+}
+
+// commitChanges applies individual changes to the cache, leaving the rest of the directory as it was. The files being
+// held back keep the state recorded while planning, so their timeouts and observations carry on, but timeouts that
+// weren't committed are reported again by the next sweep. It must be called while holding the sweep lock.
+func (cs *ChangeSet) commitChanges(changes []change) {
+	committed := make(map[string]bool, len(changes))
+	for _, change := range changes {
+		cs.wd.commitChange(change)
+		committed[change.rel] = true
+	}
+	uncommitted := make(map[string]bool)
+	for _, change := range cs.changes {
+		if change.event.Type == FileTimeout && !committed[change.rel] {
+			uncommitted[change.rel] = true
+		}
+	}
+
+	for _, update := range cs.updates {
+		if update.entries == nil {
+			continue
+		}
+		pending := maps.Clone(update.pending)
+		for name, held := range pending {
+			if uncommitted[path.Join(update.dir, name)] {
+				held.timedOut = false
+				pending[name] = held
+			}
+		}
+		cache := cs.wd.dirCacheFor(update.dir, len(pending) > 0)
+		if cache == nil {
+			continue
+		}
+		cache.mu.Lock()
+		cache.pending = pending
+		cache.mu.Unlock()
+	}
+	cs.finish()
+}
+
+func (cs *ChangeSet) finish() {
+	cs.done = true
+	cs.wd.generation++
+	cs.wd.saveLeaseState()
+}
+
+// commitChange adds or removes a single file in the cache, creating the caches for the directories leading to an added
+// file. The entries maps are replaced rather than modified, since readers may be holding a reference to them.
+func (wd *watcher) commitChange(c change) {
+	if c.event.Type == BundleReady {
+		for _, member := range c.members {
+			wd.commitChange(member)
+		}
+		return
+	}
+
+	dir, name := path.Split(c.rel)
+	cache := wd.dirCacheFor(dir, c.event.Type == FileAdded)
+	if cache == nil {
+		return // Nothing is cached for this path
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	entries := maps.Clone(cache.entries)
+	switch c.event.Type {
+	case FileAdded:
+		entries[name] = c.entry
+	case FileRemoved:
+		delete(entries, name)
+	}
+	cache.entries = entries
+}
+
+// dirCacheFor returns the cache for a directory, or nil if it isn't cached. If create is true, the caches for the
+// directory and those leading to it are created instead, and recorded in their parents' entries.
+func (wd *watcher) dirCacheFor(dir string, create bool) *dirCache {
+	cache := wd.cache
+	dir = strings.Trim(dir, "/")
+	if dir == "" || dir == "." {
+		return cache
+	}
+	for _, part := range strings.Split(dir, "/") {
+		cache.mu.Lock()
+		child := cache.children[part]
+		if child == nil && create {
+			child = newDirCache()
+			cache.children[part] = child
+			if cache.entries[part] == nil {
+				entries := maps.Clone(cache.entries)
+				entries[part] = dirEntry(part)
+				cache.entries = entries
+			}
+		}
+		cache.mu.Unlock()
+		if child == nil {
+			return nil
+		}
+		cache = child
+	}
+	return cache
+}
+
+// dirEntry returns the entry recorded for a directory that is added to the cache without being listed.
+func dirEntry(name string) fs.DirEntry {
+	return fs.FileInfoToDirEntry(snapshotFileInfo{name: name, mode: fs.ModeDir})
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+
+	"github.com/spiretechnology/go-watchdir/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// daemonMain runs several watch jobs described by a config file, reloading it on SIGHUP.
+func daemonMain(args []string) {
+	var out outputConfig
+	var status statusConfig
+	var configPath, logLevel string
+	flags := flag.NewFlagSet("watchdir daemon", flag.ExitOnError)
+	flags.StringVar(&configPath, "config", "", "`path` to a YAML or JSON file describing the jobs to run")
+	flags.StringVar(&logLevel, "log-level", "info", "default log verbosity for jobs: debug, info, warn or error")
+	out.register(flags)
+	status.register(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s -config FILE [flags]\n\nRuns each of the watch jobs in FILE, and reloads FILE on SIGHUP. Jobs that are unchanged keep running, and\njobs that are changed are restarted without losing track of the files they have already reported.\n\nFlags:\n", flags.Name())
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	// Validate the arguments before doing anything
+	if flags.NArg() != 0 {
+		usageError(flags, errors.New("unexpected arguments"))
+	}
+	if configPath == "" {
+		usageError(flags, errors.New("-config is required"))
+	}
+	level, err := parseLogLevel(logLevel)
+	if err != nil {
+		usageError(flags, fmt.Errorf("-log-level: %w", err))
+	}
+	for _, cfg := range []validator{&out, &status} {
+		if err := cfg.validate(); err != nil {
+			usageError(flags, err)
+		}
+	}
+
+	// Events are printed to stdout, and all diagnostics are logged to stderr
+	d := &daemon{
+		configPath: configPath,
+		logLevel:   logLevel,
+		logs:       newLogger(os.Stderr, level),
+		jobs:       make(map[string]*runningJob),
+	}
+	d.printer, _ = out.printer(os.Stdout)
+	d.status = newStatusServer(&status)
+
+	// Create a context that is cancelled on SIGINT/SIGTERM (Ctrl+C)
+	ctx := context.Background()
+	ctx, cancel := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	if status.enabled() {
+		go func() {
+			if err := status.serve(ctx, d.status.handler(), d.logs); err != nil {
+				d.logs.Errorf("%v", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	if err := d.run(ctx); err != nil {
+		d.logs.Errorf("%v", err)
+		os.Exit(1)
+	}
+}
+
+// daemonConfig is the format of the daemon config file.
+type daemonConfig struct {
+	Jobs []yaml.Node `yaml:"jobs"`
+}
+
+// jobConfig describes a single job in the daemon config file. Any settings that are omitted have the same defaults as
+// the corresponding flags.
+type jobConfig struct {
+	Name    string        `yaml:"name"`
+	Dir     string        `yaml:"dir"`
+	Watch   watchConfig   `yaml:",inline"`
+	Exec    execConfig    `yaml:"exec"`
+	Webhook webhookConfig `yaml:"webhook"`
+}
+
+// loadDaemonConfig reads and validates the jobs in a config file.
+func loadDaemonConfig(configPath, logLevel string) ([]jobConfig, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+
+	// JSON is a subset of YAML, so both are decoded the same way
+	var cfg daemonConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+
+	names := make(map[string]bool)
+	jobs := make([]jobConfig, 0, len(cfg.Jobs))
+	for i, node := range cfg.Jobs {
+		// Start from the flag defaults, so that omitted settings behave the same as omitted flags
+		var job jobConfig
+		flags := flag.NewFlagSet("", flag.ContinueOnError)
+		job.Watch.register(flags)
+		job.Exec.register(flags)
+		job.Webhook.register(flags)
+		job.Watch.LogLevel = logLevel
+		if err := node.Decode(&job); err != nil {
+			return nil, fmt.Errorf("parse job %d: %w", i+1, err)
+		}
+
+		if job.Name == "" {
+			return nil, fmt.Errorf("job %d: name is required", i+1)
+		}
+		if names[job.Name] {
+			return nil, fmt.Errorf("job %q: name is used by another job", job.Name)
+		}
+		names[job.Name] = true
+		if job.Dir == "" {
+			return nil, fmt.Errorf("job %q: dir is required", job.Name)
+		}
+		for _, cfg := range []validator{&job.Watch, &job.Exec, &job.Webhook} {
+			if err := cfg.validate(); err != nil {
+				return nil, fmt.Errorf("job %q: %w", job.Name, err)
+			}
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// daemon runs the jobs in a config file.
+type daemon struct {
+	configPath string
+	logLevel   string
+	printer    *eventPrinter
+	logs       *logger
+	status     *statusServer
+	jobs       map[string]*runningJob
+}
+
+// runningJob is a job that has been started by the daemon.
+type runningJob struct {
+	cfg    jobConfig
+	wd     *monitor
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// stop cancels the job and waits for it to exit.
+func (rj *runningJob) stop() {
+	rj.cancel()
+	<-rj.done
+}
+
+// exited returns true if the job has stopped running on its own.
+func (rj *runningJob) exited() bool {
+	select {
+	case <-rj.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// run starts the jobs, and reloads the config each time SIGHUP is received, until the context is cancelled.
+func (d *daemon) run(ctx context.Context) error {
+	if err := d.reload(ctx); err != nil {
+		return err
+	}
+
+	chanReload := make(chan os.Signal, 1)
+	signal.Notify(chanReload, syscall.SIGHUP)
+	defer signal.Stop(chanReload)
+
+	for {
+		select {
+		case <-ctx.Done():
+			for name, rj := range d.jobs {
+				rj.stop()
+				d.status.remove(rj.wd)
+				delete(d.jobs, name)
+			}
+			return nil
+		case <-chanReload:
+			d.logs.Infof("reloading %s", d.configPath)
+			if err := d.reload(ctx); err != nil {
+				d.logs.Errorf("%v, keeping the current jobs", err)
+			}
+		}
+	}
+}
+
+// reload reads the config file, then stops removed jobs, restarts changed jobs and starts new jobs. If the config is
+// invalid, the running jobs are left untouched.
+func (d *daemon) reload(ctx context.Context) error {
+	jobs, err := loadDaemonConfig(d.configPath, d.logLevel)
+	if err != nil {
+		return err
+	}
+	wanted := make(map[string]jobConfig, len(jobs))
+	for _, cfg := range jobs {
+		wanted[cfg.Name] = cfg
+	}
+
+	// Stop the jobs that were removed or changed, or that have exited
+	states := make(map[string]watchdir.Snapshot)
+	for name, rj := range d.jobs {
+		cfg, ok := wanted[name]
+		action := reloadActionFor(rj.cfg, rj.exited(), cfg, ok)
+		if action == keepJob {
+			continue
+		}
+		rj.stop()
+		d.status.remove(rj.wd)
+		delete(d.jobs, name)
+		switch action {
+		case stopJob:
+			d.logs.Infof("stopped job %q", name)
+		case resumeJob:
+			snap, err := rj.wd.Snapshot(ctx)
+			if err != nil {
+				d.logs.Errorf("job %q: snapshot before restart: %v", name, err)
+				continue
+			}
+			states[name] = snap
+		}
+	}
+
+	// Start the jobs that aren't running
+	for _, cfg := range jobs {
+		if _, ok := d.jobs[cfg.Name]; ok {
+			continue
+		}
+		snap, restored := states[cfg.Name]
+		d.start(ctx, cfg, snap, restored)
+	}
+	return nil
+}
+
+// reloadAction is what a reload does with a job that is already running.
+type reloadAction int
+
+const (
+	// keepJob leaves the job running, because it is unchanged.
+	keepJob reloadAction = iota
+
+	// stopJob stops the job, because it was removed from the config.
+	stopJob
+
+	// restartJob restarts the job from scratch, because it now watches a different directory.
+	restartJob
+
+	// resumeJob restarts the job with the files it already knows about, because it was changed or has exited.
+	resumeJob
+)
+
+// reloadActionFor decides what to do with a running job, given its new config if it is still in the config file.
+func reloadActionFor(current jobConfig, exited bool, wanted jobConfig, ok bool) reloadAction {
+	switch {
+	case !ok:
+		return stopJob
+	case reflect.DeepEqual(wanted, current) && !exited:
+		return keepJob
+	case wanted.Dir == current.Dir && wanted.Watch.SubRoot == current.Watch.SubRoot:
+		return resumeJob
+	default:
+		return restartJob
+	}
+}
+
+// start runs a job in the background. If restored is true, the watcher is initialized from the snapshot.
+func (d *daemon) start(ctx context.Context, cfg jobConfig, snap watchdir.Snapshot, restored bool) {
+	level, _ := parseLogLevel(cfg.Watch.LogLevel)
+	j := &job{
+		name:    cfg.Name,
+		dir:     cfg.Dir,
+		fsys:    os.DirFS(cfg.Dir),
+		watch:   cfg.Watch,
+		exec:    cfg.Exec,
+		webhook: cfg.Webhook,
+		printer: d.printer,
+		logs:    newLogger(os.Stderr, level).withPrefix(cfg.Name + ": "),
+	}
+	options := j.watch.options(j.dir, j.logs)
+	if restored {
+		options = append(options, watchdir.WithSnapshot(snap))
+		j.logs.Infof("restarting with %d known files", snap.Len())
+	} else {
+		j.logs.Infof("watching %s", cfg.Dir)
+	}
+
+	j.monitor = newMonitor(watchdir.NewDirWatcher(j.fsys, options...), cfg.Name, cfg.Dir, cfg.Watch.Interval, j.logs)
+	d.status.add(j.monitor)
+
+	ctx, cancel := context.WithCancel(ctx)
+	rj := &runningJob{
+		cfg:    cfg,
+		wd:     j.monitor,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	d.jobs[cfg.Name] = rj
+	go func() {
+		defer close(rj.done)
+		err := j.run(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			j.logs.Errorf("job exited: %v", err)
+		}
+		j.monitor.recordExit(err)
+	}()
+}
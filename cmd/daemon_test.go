@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spiretechnology/go-watchdir/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// writeConfig writes a daemon config file, and returns its path.
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	configPath := filepath.Join(t.TempDir(), "jobs.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0o644), "error writing config")
+	return configPath
+}
+
+func TestLoadDaemonConfig(t *testing.T) {
+	t.Run("applies the flag defaults", func(t *testing.T) {
+		jobs, err := loadDaemonConfig(writeConfig(t, `
+jobs:
+  - name: uploads
+    dir: /srv/uploads
+    interval: 10s
+    exclude: ["*.part"]
+  - name: reports
+    dir: /srv/reports
+`), "warn")
+		require.NoError(t, err, "error loading config")
+		require.Len(t, jobs, 2, "wrong number of jobs")
+
+		require.Equal(t, "uploads", jobs[0].Name, "wrong job name")
+		require.Equal(t, 10*time.Second, jobs[0].Watch.Interval, "configured interval should be used")
+		require.Equal(t, patternList{"*.part"}, jobs[0].Watch.Exclude, "configured patterns should be used")
+		require.Equal(t, 5*time.Second, jobs[1].Watch.Interval, "omitted interval should default to the flag")
+		require.Equal(t, time.Second, jobs[1].Watch.Stability, "omitted stability should default to the flag")
+		require.Equal(t, uint(watchdir.DefaultMaxDepth), jobs[1].Watch.MaxDepth, "omitted depth should default to the flag")
+		require.Equal(t, "warn", jobs[1].Watch.LogLevel, "omitted log level should default to the daemon's")
+	})
+	for _, tc := range []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{
+			name:   "missing name",
+			config: "jobs:\n  - dir: /srv\n",
+			err:    "name is required",
+		},
+		{
+			name:   "missing dir",
+			config: "jobs:\n  - name: a\n",
+			err:    "dir is required",
+		},
+		{
+			name:   "duplicate names",
+			config: "jobs:\n  - name: a\n    dir: /srv/a\n  - name: a\n    dir: /srv/b\n",
+			err:    `job "a": name is used by another job`,
+		},
+		{
+			name:   "invalid include pattern",
+			config: "jobs:\n  - name: a\n    dir: /srv\n    include: [\"[\"]\n",
+			err:    "-include",
+		},
+		{
+			name:   "invalid exclude pattern",
+			config: "jobs:\n  - name: a\n    dir: /srv\n    exclude: [\"[\"]\n",
+			err:    "-exclude",
+		},
+		{
+			name:   "invalid interval",
+			config: "jobs:\n  - name: a\n    dir: /srv\n    interval: 0s\n",
+			err:    "-interval",
+		},
+		{
+			name:   "malformed file",
+			config: "jobs: [",
+			err:    "parse config",
+		},
+	} {
+		t.Run("rejects "+tc.name, func(t *testing.T) {
+			_, err := loadDaemonConfig(writeConfig(t, tc.config), "info")
+			require.ErrorContains(t, err, tc.err, "wrong error")
+		})
+	}
+}
+
+func TestReloadActionFor(t *testing.T) {
+	current := jobConfig{Name: "a", Dir: "/srv/a", Watch: watchConfig{Interval: time.Second}}
+	changed := current
+	changed.Watch.Interval = time.Minute
+	subRoot := current
+	subRoot.Watch.SubRoot = "in"
+	moved := current
+	moved.Dir = "/srv/b"
+
+	for _, tc := range []struct {
+		name   string
+		wanted jobConfig
+		ok     bool
+		exited bool
+		action reloadAction
+	}{
+		{name: "keeps unchanged jobs", wanted: current, ok: true, action: keepJob},
+		{name: "stops removed jobs", ok: false, action: stopJob},
+		{name: "resumes changed jobs", wanted: changed, ok: true, action: resumeJob},
+		{name: "resumes unchanged jobs that exited", wanted: current, ok: true, exited: true, action: resumeJob},
+		{name: "restarts jobs with a new dir", wanted: moved, ok: true, action: restartJob},
+		{name: "restarts jobs with a new sub-root", wanted: subRoot, ok: true, action: restartJob},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.action, reloadActionFor(current, tc.exited, tc.wanted, tc.ok), "wrong action")
+		})
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/spiretechnology/go-watchdir/v2"
+	"golang.org/x/sync/errgroup"
+)
+
+// execConfig holds the flags that configure commands run for each event.
+type execConfig struct {
+	Command    string        `yaml:"command"`
+	Added      string        `yaml:"added"`
+	Removed    string        `yaml:"removed"`
+	TimedOut   string        `yaml:"timed_out"`
+	Bundle     string        `yaml:"bundle"`
+	Workers    int           `yaml:"workers"`
+	Timeout    time.Duration `yaml:"timeout"`
+	Retries    int           `yaml:"retries"`
+	RetryDelay time.Duration `yaml:"retry_delay"`
+}
+
+func (c *execConfig) register(flags *flag.FlagSet) {
+	flags.StringVar(&c.Command, "exec", "", "`command` to run for each added or removed file, where {} is replaced with the path of the file. Arguments are quoted as in a shell")
+	flags.StringVar(&c.Added, "exec-added", "", "`command` to run for added files, instead of -exec")
+	flags.StringVar(&c.Removed, "exec-removed", "", "`command` to run for removed files, instead of -exec")
+	flags.StringVar(&c.TimedOut, "exec-timed-out", "", "`command` to run for files that timed out waiting for a marker or the rest of their bundle. -exec is never run for them, since they may be incomplete")
+	flags.StringVar(&c.Bundle, "exec-bundle", "", "`command` to run for each complete bundle, where an argument of {} is replaced with the paths of its members")
+	flags.IntVar(&c.Workers, "exec-workers", 1, "maximum number of commands to run at the same time")
+	flags.DurationVar(&c.Timeout, "exec-timeout", 0, "time after which a command is killed, or 0 for no limit")
+	flags.IntVar(&c.Retries, "exec-retries", 0, "number of times to retry a command that exits with a non-zero status or times out")
+	flags.DurationVar(&c.RetryDelay, "exec-retry-delay", time.Second, "time to wait before retrying a command")
+}
+
+func (c *execConfig) validate() error {
+	if c.Workers < 1 {
+		return errors.New("-exec-workers must be at least 1")
+	}
+	if c.Timeout < 0 {
+		return errors.New("-exec-timeout must not be negative")
+	}
+	if c.Retries < 0 {
+		return errors.New("-exec-retries must not be negative")
+	}
+	if c.RetryDelay < 0 {
+		return errors.New("-exec-retry-delay must not be negative")
+	}
+	for _, command := range []string{c.Command, c.Added, c.Removed, c.TimedOut, c.Bundle} {
+		if command == "" {
+			continue
+		}
+		if err := validateCommand(command); err != nil {
+			return fmt.Errorf("-exec: %w", err)
+		}
+	}
+	return nil
+}
+
+// enabled returns true if any commands are configured.
+func (c *execConfig) enabled() bool {
+	return c.Command != "" || c.Added != "" || c.Removed != "" || c.TimedOut != "" || c.Bundle != ""
+}
+
+// commandFor returns the command configured for the given event type, or an empty string if there is none. The generic
+// command is only run for added and removed files, since other events don't describe a complete file at the event's
+// path.
+func (c *execConfig) commandFor(eventType watchdir.EventType) string {
+	switch eventType {
+	case watchdir.FileAdded:
+		return cmp.Or(c.Added, c.Command)
+	case watchdir.FileRemoved:
+		return cmp.Or(c.Removed, c.Command)
+	case watchdir.FileTimeout:
+		return c.TimedOut
+	case watchdir.BundleReady:
+		return c.Bundle
+	default:
+		return ""
+	}
+}
+
+// execResult describes the outcome of running the command for an event.
+type execResult struct {
+	Job      string             `json:"job,omitempty"`
+	Type     string             `json:"type"`
+	Event    watchdir.EventType `json:"event"`
+	Path     string             `json:"path"`
+	Command  string             `json:"command"`
+	ExitCode int                `json:"exit_code"`
+	Attempts int                `json:"attempts"`
+	Duration time.Duration      `json:"duration_ns"`
+	Error    string             `json:"error,omitempty"`
+	Time     time.Time          `json:"timestamp"`
+}
+
+// executor runs the configured commands for events on a pool of workers.
+type executor struct {
+	cfg    *execConfig
+	dir    string
+	stderr io.Writer
+	report func(execResult)
+
+	// strict makes run return an error if any command failed after its retries, once every event has been handled.
+	strict bool
+}
+
+// run executes commands for events received from the channel until it is closed or the context is cancelled.
+func (e *executor) run(ctx context.Context, chanEvents <-chan watchdir.Event) error {
+	var failed atomic.Int64
+	eg, ctx := errgroup.WithContext(ctx)
+	for range e.cfg.Workers {
+		eg.Go(func() error {
+			for {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case event, ok := <-chanEvents:
+					if !ok {
+						return nil // Channel closed
+					}
+					if command := e.cfg.commandFor(event.Type); command != "" {
+						result := e.execute(ctx, command, event)
+						if result.Error != "" {
+							failed.Add(1)
+						}
+						e.report(result)
+					}
+				}
+			}
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+	if n := failed.Load(); e.strict && n > 0 {
+		return fmt.Errorf("%d commands failed", n)
+	}
+	return nil
+}
+
+// execute runs the command for an event, retrying it if it fails.
+func (e *executor) execute(ctx context.Context, command string, event watchdir.Event) execResult {
+	result := execResult{
+		Type:    "exec",
+		Event:   event.Type,
+		Path:    event.File,
+		Command: command,
+	}
+	startTime := time.Now()
+attempts:
+	for {
+		result.Attempts++
+		err := e.executeOnce(ctx, command, event)
+		result.ExitCode, result.Error = 0, ""
+		if err != nil {
+			result.ExitCode, result.Error = -1, err.Error()
+		}
+
+		// Only retry commands that ran and failed, since commands that can't be started won't succeed later
+		var exitErr *exec.ExitError
+		retryable := errors.As(err, &exitErr) || errors.Is(err, context.DeadlineExceeded)
+		if exitErr != nil {
+			result.ExitCode = exitErr.ExitCode()
+		}
+		if err == nil || !retryable || result.Attempts > e.cfg.Retries {
+			break
+		}
+
+		// Wait before trying again
+		select {
+		case <-ctx.Done():
+			break attempts
+		case <-time.After(e.cfg.RetryDelay):
+		}
+	}
+	result.Duration = time.Since(startTime)
+	result.Time = time.Now()
+	return result
+}
+
+func (e *executor) executeOnce(ctx context.Context, command string, event watchdir.Event) error {
+	return e.executeFile(ctx, command, event, filepath.Join(e.dir, filepath.FromSlash(event.File)))
+}
+
+// executeFile runs the command once for an event, with {} replaced by filename. For events with members, such as
+// bundles, an argument that is exactly {} is replaced with the path of each member instead, since filename doesn't
+// exist. It is used directly when the file isn't at the event's path within the directory, such as a hot folder file
+// that was renamed when it was claimed.
+func (e *executor) executeFile(ctx context.Context, command string, event watchdir.Event, filename string) error {
+	if e.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.cfg.Timeout)
+		defer cancel()
+	}
+
+	// Split the command into arguments, replacing {} with the file path. The command isn't run through a shell, so
+	// paths never need to be quoted.
+	split, err := splitCommand(command)
+	if err != nil {
+		return err
+	}
+	var args []string
+	for _, arg := range split {
+		if arg == "{}" && len(event.Members) > 0 {
+			for _, member := range event.Members {
+				args = append(args, filepath.Join(e.dir, filepath.FromSlash(member)))
+			}
+			continue
+		}
+		args = append(args, strings.ReplaceAll(arg, "{}", filename))
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(),
+		"WATCHDIR_EVENT="+event.Type.String(),
+		"WATCHDIR_PATH="+event.File,
+		"WATCHDIR_FILE="+filename,
+		"WATCHDIR_ROOT="+e.dir,
+		"WATCHDIR_SWEEP="+strconv.FormatUint(event.Sweep, 10),
+		"WATCHDIR_MEMBERS="+strings.Join(event.Members, "\n"),
+	)
+	cmd.Stdout = e.stderr
+	cmd.Stderr = e.stderr
+	if err := cmd.Run(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fmt.Errorf("%w: %w", ctxErr, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// validateCommand checks that a command can be split into arguments, and isn't blank.
+func validateCommand(command string) error {
+	args, err := splitCommand(command)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return errors.New("command must not be blank")
+	}
+	return nil
+}
+
+// splitCommand splits a command into arguments the way a POSIX shell does, without expanding variables or globs.
+// Single quotes keep everything up to the next single quote. Double quotes keep everything up to the next unescaped
+// double quote, where a backslash only escapes $, `, " and \. Elsewhere, a backslash escapes the next character.
+func splitCommand(command string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	runes := []rune(command)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '\'':
+			end := slices.Index(runes[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated single quote")
+			}
+			arg.WriteString(string(runes[i+1 : i+1+end]))
+			i += end + 1
+			inArg = true
+		case r == '"':
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) && strings.ContainsRune("$`\"\\", runes[i+1]) {
+					i++
+				}
+				arg.WriteRune(runes[i])
+			}
+			if i == len(runes) {
+				return nil, errors.New("unterminated double quote")
+			}
+			inArg = true
+		case r == '\\':
+			if i+1 == len(runes) {
+				return nil, errors.New("trailing backslash")
+			}
+			i++
+			arg.WriteRune(runes[i])
+			inArg = true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spiretechnology/go-watchdir/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitCommand(t *testing.T) {
+	for _, tc := range []struct {
+		command string
+		args    []string
+		err     string
+	}{
+		{command: "process {}", args: []string{"process", "{}"}},
+		{command: "  process\t{}  ", args: []string{"process", "{}"}},
+		{command: `sh -c "process {}"`, args: []string{"sh", "-c", "process {}"}},
+		{command: `sh -c 'process "$1"' sh {}`, args: []string{"sh", "-c", `process "$1"`, "sh", "{}"}},
+		{command: `echo "a \"b\" \c" 'd\e'`, args: []string{"echo", `a "b" \c`, `d\e`}},
+		{command: `echo a\ b "" x''y`, args: []string{"echo", "a b", "", "xy"}},
+		{command: "", args: nil},
+		{command: `echo 'a`, err: "unterminated single quote"},
+		{command: `echo "a`, err: "unterminated double quote"},
+		{command: `echo a\`, err: "trailing backslash"},
+	} {
+		t.Run(tc.command, func(t *testing.T) {
+			args, err := splitCommand(tc.command)
+			if tc.err != "" {
+				require.ErrorContains(t, err, tc.err, "wrong error")
+				return
+			}
+			require.NoError(t, err, "error splitting command")
+			require.Equal(t, tc.args, args, "wrong arguments")
+		})
+	}
+}
+
+func TestExecutor(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		command  string
+		timeout  time.Duration
+		attempts int
+		exitCode int
+		err      string
+	}{
+		{
+			name:     "succeeds",
+			command:  `sh -c 'test -f "$1"' sh {}`,
+			attempts: 1,
+		},
+		{
+			name:     "retries failed commands",
+			command:  "sh -c 'exit 3'",
+			attempts: 3,
+			exitCode: 3,
+			err:      "exit status 3",
+		},
+		{
+			name:     "stops retrying once the command succeeds",
+			command:  `sh -c 'test -e "$1.seen" || { touch "$1.seen"; exit 1; }' sh {}`,
+			attempts: 2,
+		},
+		{
+			name:     "doesn't retry commands that can't be started",
+			command:  "./does-not-exist {}",
+			attempts: 1,
+			exitCode: -1,
+			err:      "does-not-exist",
+		},
+		{
+			name:     "kills and retries commands that time out",
+			command:  "sleep 10",
+			timeout:  50 * time.Millisecond,
+			attempts: 3,
+			exitCode: -1,
+			err:      context.DeadlineExceeded.Error(),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFile(t, filepath.Join(dir, "my file.txt"), "hello")
+			e := &executor{
+				cfg:    &execConfig{Timeout: tc.timeout, Retries: 2},
+				dir:    dir,
+				stderr: io.Discard,
+			}
+			result := e.execute(context.Background(), tc.command, watchdir.Event{Type: watchdir.FileAdded, File: "my file.txt"})
+			require.Equal(t, tc.attempts, result.Attempts, "wrong number of attempts")
+			require.Equal(t, tc.exitCode, result.ExitCode, "wrong exit code")
+			if tc.err != "" {
+				require.Contains(t, result.Error, tc.err, "wrong error")
+			} else {
+				require.Empty(t, result.Error, "command should succeed")
+			}
+			require.Less(t, result.Duration, 5*time.Second, "commands should be killed when they time out")
+		})
+	}
+}
+
+func TestCommandFor(t *testing.T) {
+	cfg := execConfig{Command: "process {}", Removed: "cleanup {}", TimedOut: "quarantine {}", Bundle: "ingest {}"}
+	for eventType, command := range map[watchdir.EventType]string{
+		watchdir.FileAdded:   "process {}",
+		watchdir.FileRemoved: "cleanup {}",
+		watchdir.FileTimeout: "quarantine {}",
+		watchdir.BundleReady: "ingest {}",
+		watchdir.Overflow:    "",
+	} {
+		require.Equal(t, command, cfg.commandFor(eventType), "wrong command for %s", eventType)
+	}
+
+	// Files that timed out may be incomplete, and bundles aren't files, so the generic command is never run for them
+	cfg = execConfig{Command: "process {}"}
+	require.Empty(t, cfg.commandFor(watchdir.FileTimeout), "generic command should not run for timed out files")
+	require.Empty(t, cfg.commandFor(watchdir.BundleReady), "generic command should not run for bundles")
+}
+
+func TestExecuteBundle(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "sub", "scan.tif"), "image")
+	writeFile(t, filepath.Join(dir, "sub", "scan.xml"), "metadata")
+	e := &executor{
+		cfg:    &execConfig{},
+		dir:    dir,
+		stderr: io.Discard,
+	}
+
+	// Every member is passed as its own argument in place of {}
+	command := `sh -c 'test "$#" -eq 2 && test -f "$1" && test -f "$2" && cat "$@" > "$0"' ` + filepath.Join(dir, "out.txt") + " {}"
+	event := watchdir.Event{Type: watchdir.BundleReady, File: "sub/scan", Members: []string{"sub/scan.tif", "sub/scan.xml"}}
+	result := e.execute(context.Background(), command, event)
+	require.Empty(t, result.Error, "command should succeed")
+
+	data, err := os.ReadFile(filepath.Join(dir, "out.txt"))
+	require.NoError(t, err, "command should have written the members")
+	require.Equal(t, "imagemetadata", string(data), "wrong members passed")
+}
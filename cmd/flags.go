@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/spiretechnology/go-watchdir/v2"
+)
+
+// validator is implemented by each group of flags, to check their values after parsing.
+type validator interface {
+	validate() error
+}
+
+// patternList is a flag that can be repeated to collect multiple glob patterns.
+type patternList []string
+
+func (p *patternList) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patternList) Set(value string) error {
+	if err := checkPattern(value); err != nil {
+		return err
+	}
+	*p = append(*p, value)
+	return nil
+}
+
+// validate checks the patterns, which is needed for patterns loaded from a config file rather than set as flags.
+func (p patternList) validate() error {
+	for _, pattern := range p {
+		if err := checkPattern(pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkPattern(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	return nil
+}
+
+// matches returns true if any of the patterns match the base name or the full path of the file.
+func (p patternList) matches(name string) bool {
+	for _, pattern := range p {
+		if ok, _ := path.Match(pattern, path.Base(name)); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// bundleList is a flag that can be repeated to collect bundle rules, each written as a name and a comma-separated list
+// of extensions, e.g. "shapefile=.shp,.shx,.dbf".
+type bundleList []string
+
+func (b *bundleList) String() string {
+	return strings.Join(*b, " ")
+}
+
+func (b *bundleList) Set(value string) error {
+	if _, err := parseBundleRule(value); err != nil {
+		return err
+	}
+	*b = append(*b, value)
+	return nil
+}
+
+// rules returns the bundle rules, with the given timeout. It must only be called after the rules are validated.
+func (b bundleList) rules(timeout time.Duration) []watchdir.BundleRule {
+	rules := make([]watchdir.BundleRule, 0, len(b))
+	for _, value := range b {
+		rule, _ := parseBundleRule(value)
+		rule.Timeout = timeout
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func parseBundleRule(value string) (watchdir.BundleRule, error) {
+	name, extensions, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return watchdir.BundleRule{}, fmt.Errorf("invalid bundle %q: expected name=.ext,.ext", value)
+	}
+	rule := watchdir.BundleRule{Name: name}
+	for _, ext := range strings.Split(extensions, ",") {
+		ext = strings.TrimSpace(ext)
+		if !strings.HasPrefix(ext, ".") || len(ext) < 2 {
+			return watchdir.BundleRule{}, fmt.Errorf("invalid bundle %q: extension %q must begin with a dot", value, ext)
+		}
+		rule.Extensions = append(rule.Extensions, ext)
+	}
+	if len(rule.Extensions) < 2 {
+		return watchdir.BundleRule{}, fmt.Errorf("invalid bundle %q: at least two extensions are required", value)
+	}
+	return rule, nil
+}
+
+// watchConfig holds the flags that configure the watcher. The same settings can be provided for each job in a daemon
+// config file, using the names in the field tags.
+type watchConfig struct {
+	Interval      time.Duration `yaml:"interval"`
+	Stability     time.Duration `yaml:"stability"`
+	StableSweeps  int           `yaml:"stable_sweeps"`
+	MaxDepth      uint          `yaml:"max_depth"`
+	SubRoot       string        `yaml:"sub_root"`
+	Events        string        `yaml:"events"`
+	Include       patternList   `yaml:"include"`
+	Exclude       patternList   `yaml:"exclude"`
+	Hidden        bool          `yaml:"hidden"`
+	MarkerSuffix  string        `yaml:"marker_suffix"`
+	DirMarker     string        `yaml:"dir_marker"`
+	HideMarkers   bool          `yaml:"hide_markers"`
+	MarkerTimeout time.Duration `yaml:"marker_timeout"`
+	Bundles       bundleList    `yaml:"bundles"`
+	BundleTimeout time.Duration `yaml:"bundle_timeout"`
+	OpenCheck     bool          `yaml:"open_check"`
+	LogLevel      string        `yaml:"log_level"`
+}
+
+func (c *watchConfig) register(flags *flag.FlagSet) {
+	flags.DurationVar(&c.Interval, "interval", 5*time.Second, "time to wait between sweeps")
+	flags.DurationVar(&c.Stability, "stability", time.Second, "time since last modification before a file is reported, or 0 to report files immediately")
+	flags.IntVar(&c.StableSweeps, "stable-sweeps", 0, "report files once their size and modification time are unchanged for this many consecutive sweeps, instead of using -stability")
+	flags.UintVar(&c.MaxDepth, "max-depth", watchdir.DefaultMaxDepth, "maximum directory depth to sweep")
+	flags.StringVar(&c.SubRoot, "sub-root", "", "subdirectory of the watch directory to sweep, while reporting paths relative to the watch directory")
+	flags.StringVar(&c.Events, "events", "all", "comma-separated event types to report: added, removed, modified (diff only), timeout, bundle or all")
+	flags.Var(&c.Include, "include", "only report files whose name or path matches the glob `pattern` (repeatable)")
+	flags.Var(&c.Exclude, "exclude", "ignore files and directories whose name or path matches the glob `pattern` (repeatable)")
+	flags.BoolVar(&c.Hidden, "hidden", false, "report files whose names begin with a dot")
+	flags.StringVar(&c.MarkerSuffix, "marker-suffix", "", "only report a file once a marker with this suffix appears beside it, e.g. .done")
+	flags.StringVar(&c.DirMarker, "dir-marker", "", "only report the files in a directory and its subdirectories once a marker with this name appears in it, e.g. _SUCCESS")
+	flags.BoolVar(&c.HideMarkers, "hide-markers", false, "don't report the marker files themselves")
+	flags.DurationVar(&c.MarkerTimeout, "marker-timeout", 0, "report a timeout for files still waiting for their marker after this long, or 0 to wait indefinitely")
+	flags.Var(&c.Bundles, "bundle", "report files sharing a stem together once every extension is present, as `name=.ext,.ext` (repeatable)")
+	flags.DurationVar(&c.BundleTimeout, "bundle-timeout", 0, "report a timeout for bundles still missing members after this long, or 0 to wait indefinitely")
+	flags.BoolVar(&c.OpenCheck, "open-check", false, "on Linux, hold back files that a process still has open for writing")
+	flags.StringVar(&c.LogLevel, "log-level", "info", "log verbosity: debug, info, warn or error")
+}
+
+func (c *watchConfig) validate() error {
+	if c.Interval <= 0 {
+		return errors.New("-interval must be positive")
+	}
+	if c.Stability < 0 {
+		return errors.New("-stability must not be negative")
+	}
+	if c.StableSweeps < 0 {
+		return errors.New("-stable-sweeps must not be negative")
+	}
+	if c.MaxDepth == 0 {
+		return errors.New("-max-depth must be at least 1")
+	}
+	if c.MarkerTimeout < 0 {
+		return errors.New("-marker-timeout must not be negative")
+	}
+	if (c.HideMarkers || c.MarkerTimeout > 0) && c.MarkerSuffix == "" && c.DirMarker == "" {
+		return errors.New("-hide-markers and -marker-timeout require -marker-suffix or -dir-marker")
+	}
+	for _, value := range c.Bundles {
+		if _, err := parseBundleRule(value); err != nil {
+			return fmt.Errorf("-bundle: %w", err)
+		}
+	}
+	if c.BundleTimeout < 0 {
+		return errors.New("-bundle-timeout must not be negative")
+	}
+	if err := c.Include.validate(); err != nil {
+		return fmt.Errorf("-include: %w", err)
+	}
+	if err := c.Exclude.validate(); err != nil {
+		return fmt.Errorf("-exclude: %w", err)
+	}
+	if _, err := parseEventMask(c.Events); err != nil {
+		return fmt.Errorf("-events: %w", err)
+	}
+	if _, err := parseLogLevel(c.LogLevel); err != nil {
+		return fmt.Errorf("-log-level: %w", err)
+	}
+	return nil
+}
+
+// options maps the flags onto the watcher options for a directory. It must only be called after validate succeeds.
+func (c *watchConfig) options(dir string, logs *logger) []watchdir.Option {
+	return append(c.hotFolderOptions(dir, logs), watchdir.WithDirFilter(c.dirFilter()))
+}
+
+// hotFolderOptions returns the same options as options, except for the directory filter, which a hot folder sets
+// itself. Pass dirFilter as the hot folder's DirFilter instead.
+func (c *watchConfig) hotFolderOptions(dir string, logs *logger) []watchdir.Option {
+	mask, _ := parseEventMask(c.Events)
+
+	opts := append(c.fileOptions(logs),
+		watchdir.WithEvents(mask),
+		watchdir.WithWriteStabilityThreshold(c.Stability),
+		watchdir.WithStableObservations(c.StableSweeps),
+	)
+	if c.MarkerSuffix != "" || c.DirMarker != "" {
+		opts = append(opts, watchdir.WithMarkers(watchdir.MarkerConfig{
+			Suffix:    c.MarkerSuffix,
+			DirMarker: c.DirMarker,
+			Hide:      c.HideMarkers,
+			Timeout:   c.MarkerTimeout,
+		}))
+	}
+	if c.OpenCheck {
+		opts = append(opts, watchdir.WithOpenFileCheck(dir))
+	}
+	if len(c.Bundles) > 0 {
+		opts = append(opts, watchdir.WithBundles(c.Bundles.rules(c.BundleTimeout)...))
+	}
+	return opts
+}
+
+// traversalOptions maps only the flags that decide which files are swept onto watcher options, leaving out the ones
+// that hold files back until they are ready. It must only be called after validate succeeds.
+func (c *watchConfig) traversalOptions(logs *logger) []watchdir.Option {
+	return append(c.fileOptions(logs), watchdir.WithDirFilter(c.dirFilter()))
+}
+
+// fileOptions returns the traversal options other than the directory filter.
+func (c *watchConfig) fileOptions(logs *logger) []watchdir.Option {
+	return []watchdir.Option{
+		watchdir.WithMaxDepth(c.MaxDepth),
+		watchdir.WithSubRoot(c.SubRoot),
+		watchdir.WithLogger(logs.library()),
+		watchdir.WithFileFilter(watchdir.FilterFunc(func(ctx context.Context, filename string) (bool, error) {
+			if !c.Hidden && strings.HasPrefix(path.Base(filename), ".") {
+				return false, nil
+			}
+			if c.Exclude.matches(filename) {
+				return false, nil
+			}
+			if len(c.Include) > 0 && !c.Include.matches(filename) {
+				return false, nil
+			}
+			return true, nil
+		})),
+	}
+}
+
+// dirFilter returns the filter that skips excluded directories. The root is never excluded, so that patterns such as
+// ".*" don't match it as ".".
+func (c *watchConfig) dirFilter() watchdir.Filter {
+	return watchdir.FilterFunc(func(ctx context.Context, dir string) (bool, error) {
+		return dir == "" || dir == "." || !c.Exclude.matches(dir), nil
+	})
+}
+
+// parseEventMask parses a comma-separated list of event type names into a mask.
+func parseEventMask(value string) (watchdir.EventType, error) {
+	var mask watchdir.EventType
+	for _, name := range strings.Split(value, ",") {
+		switch strings.TrimSpace(name) {
+		case "all":
+			mask |= watchdir.AllEvents
+		case watchdir.FileAdded.String():
+			mask |= watchdir.FileAdded
+		case watchdir.FileRemoved.String():
+			mask |= watchdir.FileRemoved
+		case watchdir.FileModified.String():
+			mask |= watchdir.FileModified
+		case watchdir.FileTimeout.String():
+			mask |= watchdir.FileTimeout
+		case watchdir.BundleReady.String():
+			mask |= watchdir.BundleReady
+		default:
+			return 0, fmt.Errorf("unknown event type %q", name)
+		}
+	}
+	return mask, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"testing"
+
+	"github.com/spiretechnology/go-memfs"
+	"github.com/spiretechnology/go-watchdir/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// defaultWatchConfig returns the watch settings with the flag defaults.
+func defaultWatchConfig() watchConfig {
+	var c watchConfig
+	c.register(flag.NewFlagSet("", flag.ContinueOnError))
+	return c
+}
+
+func TestWatchConfig(t *testing.T) {
+	t.Run("excluding dot directories keeps the root", func(t *testing.T) {
+		c := defaultWatchConfig()
+		c.Stability = 0
+		require.NoError(t, c.Exclude.Set(".*"), "error setting pattern")
+		require.NoError(t, c.validate(), "config should be valid")
+
+		fsys := memfs.FS{
+			"foo":         memfs.File("hello"),
+			".git/config": memfs.File("world"),
+			"sub/bar":     memfs.File("golang"),
+		}
+		cs, err := watchdir.NewDirWatcher(fsys, c.options(".", newLogger(io.Discard, levelError))...).Plan(context.Background())
+		require.NoError(t, err, "error planning")
+		var files []string
+		for _, event := range cs.Events() {
+			files = append(files, event.File)
+		}
+		require.Equal(t, []string{"foo", "sub/bar"}, files, "wrong files found")
+	})
+	t.Run("rejects invalid patterns from config files", func(t *testing.T) {
+		c := defaultWatchConfig()
+		c.Include = patternList{"["}
+		require.ErrorContains(t, c.validate(), "-include", "should reject invalid include patterns")
+		c.Include, c.Exclude = nil, patternList{"["}
+		require.ErrorContains(t, c.validate(), "-exclude", "should reject invalid exclude patterns")
+	})
+}
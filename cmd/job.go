@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/spiretechnology/go-watchdir/v2"
+	"golang.org/x/sync/errgroup"
+)
+
+// job watches a single directory and delivers its events to the configured outputs.
+type job struct {
+	name    string
+	dir     string
+	fsys    fs.FS
+	watch   watchConfig
+	exec    execConfig
+	webhook webhookConfig
+	printer *eventPrinter
+	logs    *logger
+	monitor *monitor
+	once    bool
+}
+
+// run watches the directory with the job's monitored watcher until the context is cancelled or an output fails. In
+// one-shot mode it performs a single sweep, and returns once every output has finished with its events.
+func (j *job) run(ctx context.Context) error {
+	// Create the webhook sink before starting anything, so that a failure doesn't leave goroutines behind
+	var sink *watchdir.WebhookSink
+	if j.webhook.enabled() {
+		var err error
+		sink, err = j.webhook.sink(j.logs, j.once)
+		if err != nil {
+			return fmt.Errorf("create webhook sink: %w", err)
+		}
+	}
+
+	eg, ctx := errgroup.WithContext(ctx)
+
+	chanEvents := make(chan watchdir.Event)
+	eg.Go(func() error {
+		defer close(chanEvents)
+		if j.once {
+			return j.monitor.Sweep(ctx, chanEvents)
+		}
+		return watchdir.Watch(ctx, j.monitor, j.watch.Interval, chanEvents)
+	})
+
+	// Start each of the configured outputs, which each receive every event
+	var outputs []chan watchdir.Event
+	if j.exec.enabled() {
+		chanExec := make(chan watchdir.Event)
+		outputs = append(outputs, chanExec)
+		runner := &executor{
+			cfg:    &j.exec,
+			dir:    j.dir,
+			stderr: os.Stderr,
+			report: j.reportExec,
+			strict: j.once,
+		}
+		eg.Go(func() error {
+			return runner.run(ctx, chanExec)
+		})
+	}
+	if sink != nil {
+		chanWebhook := make(chan watchdir.Event)
+		outputs = append(outputs, chanWebhook)
+		eg.Go(func() error {
+			return sink.Run(ctx, chanWebhook)
+		})
+	}
+
+	eg.Go(func() error {
+		defer func() {
+			for _, output := range outputs {
+				close(output)
+			}
+		}()
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case event, ok := <-chanEvents:
+				if !ok {
+					return nil // Channel closed
+				}
+				j.monitor.recordEvent(event)
+				if err := j.printer.print(newEventRecord(j.fsys, j.name, event)); err != nil {
+					return fmt.Errorf("print event: %w", err)
+				}
+				for _, output := range outputs {
+					select {
+					case <-ctx.Done():
+						return ctx.Err()
+					case output <- event:
+					}
+				}
+			}
+		}
+	})
+	return eg.Wait()
+}
+
+// reportExec prints the result of a command alongside the events in JSON mode, and logs it otherwise.
+func (j *job) reportExec(result execResult) {
+	result.Job = j.name
+	if ok, err := j.printer.printJSON(result); ok {
+		if err != nil {
+			j.logs.Errorf("print exec result: %v", err)
+		}
+		return
+	}
+	if result.Error != "" {
+		j.logs.Errorf("exec %q for %s failed after %d attempts: %s", result.Command, result.Path, result.Attempts, result.Error)
+	} else {
+		j.logs.Infof("exec %q for %s succeeded after %d attempts", result.Command, result.Path, result.Attempts)
+	}
+}
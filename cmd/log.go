@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+)
+
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+func parseLogLevel(value string) (logLevel, error) {
+	switch value {
+	case "debug":
+		return levelDebug, nil
+	case "info":
+		return levelInfo, nil
+	case "warn":
+		return levelWarn, nil
+	case "error":
+		return levelError, nil
+	default:
+		return 0, fmt.Errorf("unknown log level %q", value)
+	}
+}
+
+// logger writes log lines at or above a minimum level.
+type logger struct {
+	level  logLevel
+	out    io.Writer
+	prefix string
+	log    *log.Logger
+}
+
+func newLogger(out io.Writer, level logLevel) *logger {
+	return &logger{
+		level: level,
+		out:   out,
+		log:   log.New(out, "", log.LstdFlags),
+	}
+}
+
+// withPrefix returns a logger at the same level that prefixes each line.
+func (l *logger) withPrefix(prefix string) *logger {
+	return &logger{
+		level:  l.level,
+		out:    l.out,
+		prefix: prefix,
+		log:    log.New(l.out, prefix, log.LstdFlags|log.Lmsgprefix),
+	}
+}
+
+// library returns a logger for the watchdir library. The library logs every sweep, so it is only enabled when
+// debugging.
+func (l *logger) library() *log.Logger {
+	out := io.Discard
+	if l.level <= levelDebug {
+		out = l.out
+	}
+	if l.prefix != "" {
+		return log.New(out, "[watchdir] "+l.prefix, log.LstdFlags|log.Lmsgprefix)
+	}
+	return log.New(out, "[watchdir] ", log.LstdFlags)
+}
+
+func (l *logger) Infof(format string, args ...any) {
+	l.logf(levelInfo, format, args...)
+}
+
+func (l *logger) Errorf(format string, args ...any) {
+	l.logf(levelError, format, args...)
+}
+
+func (l *logger) logf(level logLevel, format string, args ...any) {
+	if level < l.level {
+		return
+	}
+	l.log.Printf(format, args...)
+}
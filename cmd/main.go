@@ -2,66 +2,156 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"flag"
+	"fmt"
 	"os"
 	"os/signal"
-	"path"
-	"strings"
 	"syscall"
-	"time"
 
 	"github.com/spiretechnology/go-watchdir/v2"
-	"golang.org/x/sync/errgroup"
 )
 
 func main() {
-	if len(os.Args) < 2 {
-		log.Fatalln("Please provide a watch directory argument")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "daemon":
+			daemonMain(os.Args[2:])
+			return
+		case "snapshot":
+			snapshotMain(os.Args[2:])
+			return
+		case "diff":
+			diffMain(os.Args[2:])
+			return
+		case "process":
+			processMain(os.Args[2:])
+			return
+		}
+	}
+
+	j := &job{}
+	var out outputConfig
+	var status statusConfig
+	once := onceConfig{watch: &j.watch}
+	flags := flag.NewFlagSet("watchdir", flag.ExitOnError)
+	j.watch.register(flags)
+	out.register(flags)
+	status.register(flags)
+	once.register(flags)
+	j.exec.register(flags)
+	j.webhook.register(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %[1]s [flags] DIR\n       %[1]s daemon -config FILE [flags]\n       %[1]s snapshot [flags] DIR\n       %[1]s diff [flags] MANIFEST MANIFEST|DIR\n       %[1]s process -command COMMAND [flags] DIR\n\nWatches DIR and reports files as they are added and removed.\n\nFlags:\n", flags.Name())
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(os.Args[1:])
+
+	// Validate the arguments before doing anything
+	if flags.NArg() != 1 {
+		usageError(flags, errors.New("please provide a single watch directory argument"))
+	}
+	for _, cfg := range []validator{&j.watch, &out, &status, &once, &j.exec, &j.webhook} {
+		if err := cfg.validate(); err != nil {
+			usageError(flags, err)
+		}
 	}
+	j.dir = flags.Arg(0)
+	j.fsys = os.DirFS(j.dir)
+	j.once = once.once
 
-	dir := os.Args[1]
+	// Events are printed to stdout, and all diagnostics are logged to stderr
+	level, _ := parseLogLevel(j.watch.LogLevel)
+	j.logs = newLogger(os.Stderr, level)
+	j.printer, _ = out.printer(os.Stdout)
 
 	// Create a context that is cancelled on SIGINT/SIGTERM (Ctrl+C)
 	ctx := context.Background()
 	ctx, cancel := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	wd := watchdir.New(
-		os.DirFS(dir),
-		watchdir.WithWriteStabilityThreshold(time.Second),
-		watchdir.WithFileFilter(watchdir.FilterFunc(func(ctx context.Context, filename string) (bool, error) {
-			if strings.HasPrefix(path.Base(filename), ".") {
-				return false, nil
-			}
-			return true, nil
-		})),
-	)
-	eg, ctx := errgroup.WithContext(ctx)
+	if j.once {
+		os.Exit(runOnce(ctx, j, &once))
+	}
 
-	chanEvents := make(chan watchdir.Event)
-	eg.Go(func() error {
-		defer close(chanEvents)
-		return watchdir.Watch(ctx, wd, 0, chanEvents)
-	})
-	eg.Go(func() error {
-		for {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			case event, ok := <-chanEvents:
-				if !ok {
-					return nil // Channel closed
-				}
-				switch event.Type {
-				case watchdir.FileAdded:
-					log.Printf("[+] %s\n", event.File)
-				case watchdir.FileRemoved:
-					log.Printf("[-] %s\n", event.File)
-				}
+	j.logs.Infof("watching %s", j.dir)
+	wd := watchdir.NewDirWatcher(j.fsys, j.watch.options(j.dir, j.logs)...)
+	j.monitor = newMonitor(wd, j.name, j.dir, j.watch.Interval, j.logs)
+	if status.enabled() {
+		server := newStatusServer(&status)
+		server.add(j.monitor)
+		go func() {
+			if err := status.serve(ctx, server.handler(), j.logs); err != nil {
+				j.logs.Errorf("%v", err)
+				os.Exit(1)
 			}
+		}()
+	}
+	if err := j.run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		j.logs.Errorf("%v", err)
+		os.Exit(1)
+	}
+}
+
+// runOnce performs a single sweep against the saved state, saves the new state, and returns the exit status that
+// indicates whether anything changed.
+func runOnce(ctx context.Context, j *job, once *onceConfig) int {
+	restore, err := once.load()
+	if err != nil {
+		j.logs.Errorf("load state: %v", err)
+		return exitError
+	}
+	wd := watchdir.NewDirWatcher(j.fsys, append(j.watch.options(j.dir, j.logs), restore...)...)
+	if once.dryRun {
+		return dryRun(ctx, j, wd)
+	}
+	j.monitor = newMonitor(wd, j.name, j.dir, j.watch.Interval, j.logs)
+	if err := j.run(ctx); err != nil {
+		j.logs.Errorf("%v", err)
+		return exitError
+	}
+
+	// The state is only saved once every output has handled the events successfully, so that a run in which any output
+	// failed, such as a command that still failed after its retries, is repeated next time
+	snap, err := wd.Snapshot(ctx)
+	if err == nil {
+		err = once.save(snap)
+	}
+	if err != nil {
+		j.logs.Errorf("save state: %v", err)
+		return exitError
+	}
+	if j.monitor.changes() > 0 {
+		return exitChanged
+	}
+	return exitUnchanged
+}
+
+// dryRun prints the changes a sweep would find without running the outputs or saving the state, and returns the exit
+// status.
+func dryRun(ctx context.Context, j *job, wd watchdir.DirWatcher) int {
+	cs, err := wd.Plan(ctx)
+	if err != nil {
+		j.logs.Errorf("%v", err)
+		return exitError
+	}
+	events := cs.Events()
+	cs.Discard()
+	for _, event := range events {
+		if err := j.printer.print(newEventRecord(j.fsys, j.name, event)); err != nil {
+			j.logs.Errorf("print event: %v", err)
+			return exitError
 		}
-	})
-	if err := eg.Wait(); err != nil {
-		log.Fatal(err)
 	}
+	if len(events) > 0 {
+		return exitChanged
+	}
+	return exitUnchanged
+}
+
+// usageError prints an error along with the usage message, and exits with the conventional status for bad arguments.
+func usageError(flags *flag.FlagSet, err error) {
+	fmt.Fprintf(flags.Output(), "%s: %v\n", flags.Name(), err)
+	flags.Usage()
+	os.Exit(2)
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/fs"
+
+	"github.com/spiretechnology/go-watchdir/v2"
+)
+
+// Exit codes used in one-shot mode.
+const (
+	exitUnchanged = 0
+	exitChanged   = 1
+	exitError     = 2
+)
+
+// onceConfig holds the flags for one-shot mode, where a single sweep is compared against the state saved by the
+// previous run. Only the known files are saved, so watch settings that track files across sweeps are rejected.
+type onceConfig struct {
+	once      bool
+	statePath string
+	dryRun    bool
+	watch     *watchConfig
+}
+
+func (c *onceConfig) register(flags *flag.FlagSet) {
+	flags.BoolVar(&c.once, "once", false, "perform a single sweep and exit with 0 if nothing changed, 1 if files changed, or 2 on errors")
+	flags.StringVar(&c.statePath, "state", "", "`file` that the known files are loaded from before the sweep, and saved to after it (requires -once)")
+	flags.BoolVar(&c.dryRun, "dry-run", false, "print the changes without running any outputs or saving the state (requires -once)")
+}
+
+func (c *onceConfig) validate() error {
+	if c.statePath != "" && !c.once {
+		return errors.New("-state requires -once")
+	}
+	if c.dryRun && !c.once {
+		return errors.New("-dry-run requires -once")
+	}
+	if !c.once || c.watch == nil {
+		return nil
+	}
+	if c.watch.StableSweeps > 1 {
+		return errors.New("-stable-sweeps can't be used with -once, since a single sweep can't see a file more than once")
+	}
+	if c.watch.MarkerTimeout > 0 {
+		return errors.New("-marker-timeout can't be used with -once, since files waiting for markers aren't saved in the state")
+	}
+	if c.watch.BundleTimeout > 0 {
+		return errors.New("-bundle-timeout can't be used with -once, since incomplete bundles aren't saved in the state")
+	}
+	return nil
+}
+
+// load returns the options that restore the state saved by the previous run. If there is no state file yet, every
+// file is reported as added.
+func (c *onceConfig) load() ([]watchdir.Option, error) {
+	if c.statePath == "" {
+		return nil, nil
+	}
+	snap, err := readManifest(c.statePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []watchdir.Option{watchdir.WithSnapshot(snap)}, nil
+}
+
+// save writes the files known to the watcher to the state file.
+func (c *onceConfig) save(snap watchdir.Snapshot) error {
+	if c.statePath == "" {
+		return nil
+	}
+	return writeManifest(c.statePath, snap)
+}
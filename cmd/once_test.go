@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnceConfig(t *testing.T) {
+	t.Run("rejects settings that need more than one sweep", func(t *testing.T) {
+		watch := defaultWatchConfig()
+		watch.MarkerSuffix = ".done"
+		c := onceConfig{once: true, watch: &watch}
+		require.NoError(t, c.validate(), "config should be valid")
+
+		watch.StableSweeps = 2
+		require.ErrorContains(t, c.validate(), "-stable-sweeps", "should reject stable sweeps")
+		watch.StableSweeps = 1
+		require.NoError(t, c.validate(), "a single stable sweep should be allowed")
+
+		watch.MarkerTimeout = time.Minute
+		require.ErrorContains(t, c.validate(), "-marker-timeout", "should reject marker timeouts")
+		watch.MarkerTimeout = 0
+
+		watch.BundleTimeout = time.Minute
+		require.ErrorContains(t, c.validate(), "-bundle-timeout", "should reject bundle timeouts")
+
+		c.once = false
+		require.NoError(t, c.validate(), "settings should be allowed when watching")
+	})
+}
+
+// onceRunner returns a function that runs a one-shot sweep of dir against a state file, like separate invocations of
+// the command, and returns its exit status. Each call creates a new job, so only the state file is shared.
+func onceRunner(t *testing.T, dir string, configure func(j *job, once *onceConfig)) func() int {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	return func() int {
+		j := &job{
+			dir:     dir,
+			fsys:    os.DirFS(dir),
+			watch:   defaultWatchConfig(),
+			printer: &eventPrinter{w: io.Discard},
+			logs:    newLogger(io.Discard, levelError),
+			once:    true,
+		}
+		j.exec.register(flag.NewFlagSet("", flag.ContinueOnError))
+		j.webhook.register(flag.NewFlagSet("", flag.ContinueOnError))
+		j.watch.Stability = 0
+		once := onceConfig{once: true, statePath: statePath, watch: &j.watch}
+		if configure != nil {
+			configure(j, &once)
+		}
+		for _, cfg := range []validator{&j.watch, &once, &j.exec, &j.webhook} {
+			require.NoError(t, cfg.validate(), "config should be valid")
+		}
+		return runOnce(context.Background(), j, &once)
+	}
+}
+
+func TestRunOnce(t *testing.T) {
+	t.Run("saves the state between runs", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, "a.txt"), "a")
+		run := onceRunner(t, dir, nil)
+		require.Equal(t, exitChanged, run(), "the first run should report every file")
+		require.Equal(t, exitUnchanged, run(), "nothing changed since the last run")
+
+		writeFile(t, filepath.Join(dir, "sub", "b.txt"), "b")
+		require.Equal(t, exitChanged, run(), "an added file should be reported")
+		require.Equal(t, exitUnchanged, run(), "the added file should be saved")
+
+		require.NoError(t, os.Remove(filepath.Join(dir, "a.txt")), "error removing file")
+		require.Equal(t, exitChanged, run(), "a removed file should be reported")
+		require.Equal(t, exitUnchanged, run(), "the removal should be saved")
+	})
+	t.Run("dry runs don't save the state", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, "a.txt"), "a")
+		dryRun := true
+		run := onceRunner(t, dir, func(j *job, once *onceConfig) {
+			once.dryRun = dryRun
+		})
+		require.Equal(t, exitChanged, run(), "a dry run should report changes")
+		require.Equal(t, exitChanged, run(), "a dry run should not save the state")
+
+		dryRun = false
+		require.Equal(t, exitChanged, run(), "the changes should still be reported")
+		require.Equal(t, exitUnchanged, run(), "the state should be saved")
+	})
+	t.Run("failed commands keep the previous state", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, "a.txt"), "a")
+		command := "false {}"
+		run := onceRunner(t, dir, func(j *job, once *onceConfig) {
+			j.exec.Command = command
+			j.exec.Retries = 1
+			j.exec.RetryDelay = 0
+		})
+		require.Equal(t, exitError, run(), "a failed command should fail the run")
+		require.Equal(t, exitError, run(), "the file should be retried by the next run")
+
+		command = "true {}"
+		require.Equal(t, exitChanged, run(), "the file should be reported once the command succeeds")
+		require.Equal(t, exitUnchanged, run(), "the state should be saved")
+	})
+	t.Run("undelivered webhooks fail the run", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, "a.txt"), "a")
+		var down atomic.Bool
+		down.Store(true)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if down.Load() {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			}
+		}))
+		defer server.Close()
+		run := onceRunner(t, dir, func(j *job, once *onceConfig) {
+			j.webhook.URL = server.URL
+			j.webhook.BatchInterval = time.Millisecond
+			j.webhook.Attempts = 1
+		})
+		require.Equal(t, exitError, run(), "an endpoint that is down should fail the run")
+
+		down.Store(false)
+		require.Equal(t, exitChanged, run(), "the file should be reported once the endpoint is up")
+		require.Equal(t, exitUnchanged, run(), "the state should be saved")
+	})
+}
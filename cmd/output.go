@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/spiretechnology/go-watchdir/v2"
+)
+
+// outputConfig holds the flags that control how events are printed.
+type outputConfig struct {
+	output string
+	format string
+}
+
+func (c *outputConfig) register(flags *flag.FlagSet) {
+	flags.StringVar(&c.output, "output", "text", "event output mode: text, json (one JSON object per line) or template")
+	flags.StringVar(&c.format, "format", "", "Go text/template executed for each event when -output is template, e.g. '{{.Type}} {{.Path}}'")
+}
+
+func (c *outputConfig) validate() error {
+	_, err := c.printer(io.Discard)
+	return err
+}
+
+// printer creates the event printer described by the flags.
+func (c *outputConfig) printer(w io.Writer) (*eventPrinter, error) {
+	p := &eventPrinter{w: w}
+	switch c.output {
+	case "text":
+	case "json":
+		p.encoder = json.NewEncoder(w)
+		p.encoder.SetEscapeHTML(false)
+	case "template":
+		if c.format == "" {
+			return nil, errors.New("-format is required when -output is template")
+		}
+		tmpl, err := template.New("format").Parse(c.format)
+		if err != nil {
+			return nil, fmt.Errorf("-format: %w", err)
+		}
+		p.tmpl = tmpl
+	default:
+		return nil, fmt.Errorf("-output: unknown output mode %q", c.output)
+	}
+	return p, nil
+}
+
+// eventRecord is the printed form of an event.
+type eventRecord struct {
+	Job     string             `json:"job,omitempty"`
+	Type    watchdir.EventType `json:"type"`
+	Path    string             `json:"path"`
+	Size    *int64             `json:"size,omitempty"`
+	ModTime *time.Time         `json:"mtime,omitempty"`
+	Sweep   uint64             `json:"sweep"`
+	Members []string           `json:"members,omitempty"`
+	Time    time.Time          `json:"timestamp"`
+}
+
+// newEventRecord creates the record for an event. The size and modification time are included if the file exists.
+func newEventRecord(fsys fs.FS, jobName string, event watchdir.Event) eventRecord {
+	record := eventRecord{
+		Job:     jobName,
+		Type:    event.Type,
+		Path:    event.File,
+		Sweep:   event.Sweep,
+		Members: event.Members,
+		Time:    time.Now(),
+	}
+	if event.Type == watchdir.FileAdded {
+		if stat, err := fs.Stat(fsys, event.File); err == nil {
+			size, modTime := stat.Size(), stat.ModTime()
+			record.Size = &size
+			record.ModTime = &modTime
+		}
+	}
+	return record
+}
+
+// newDiffRecord creates the record for an event found by comparing snapshots. The size and modification time are taken
+// from the newer snapshot, if the file exists in it.
+func newDiffRecord(snap watchdir.Snapshot, event watchdir.Event) eventRecord {
+	record := eventRecord{
+		Type: event.Type,
+		Path: event.File,
+		Time: time.Now(),
+	}
+	if entry, ok := snap.Lookup(event.File); ok {
+		record.Size = &entry.Size
+		record.ModTime = &entry.ModTime
+	}
+	return record
+}
+
+// eventPrinter writes event records to an output stream. It is safe for concurrent use.
+type eventPrinter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	encoder *json.Encoder
+	tmpl    *template.Template
+}
+
+func (p *eventPrinter) print(record eventRecord) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch {
+	case p.encoder != nil:
+		return p.encoder.Encode(record)
+	case p.tmpl != nil:
+		if err := p.tmpl.Execute(p.w, record); err != nil {
+			return err
+		}
+		_, err := io.WriteString(p.w, "\n")
+		return err
+	default:
+		prefix := "?"
+		switch record.Type {
+		case watchdir.FileAdded:
+			prefix = "+"
+		case watchdir.FileRemoved:
+			prefix = "-"
+		case watchdir.FileModified:
+			prefix = "~"
+		case watchdir.FileTimeout:
+			prefix = "!"
+		case watchdir.BundleReady:
+			prefix = "*"
+		}
+		if record.Job != "" {
+			_, err := fmt.Fprintf(p.w, "[%s] %s: %s\n", prefix, record.Job, record.Path)
+			return err
+		}
+		_, err := fmt.Fprintf(p.w, "[%s] %s\n", prefix, record.Path)
+		return err
+	}
+}
+
+// printJSON writes any other kind of record to the output if it is in JSON mode, and returns false otherwise.
+func (p *eventPrinter) printJSON(v any) (bool, error) {
+	if p.encoder == nil {
+		return false, nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return true, p.encoder.Encode(v)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/spiretechnology/go-watchdir/v2"
+)
+
+// processConfig holds the flags that configure hot folder processing.
+type processConfig struct {
+	command    string
+	workers    int
+	retries    int
+	retryDelay time.Duration
+	timeout    time.Duration
+	workDir    string
+	instance   string
+	doneDir    string
+	failedDir  string
+}
+
+func (c *processConfig) register(flags *flag.FlagSet) {
+	flags.StringVar(&c.command, "command", "", "`command` that processes each file, where {} is replaced with the path of the claimed file. Arguments are quoted as in a shell")
+	flags.IntVar(&c.workers, "workers", 1, "maximum number of files to process at the same time")
+	flags.IntVar(&c.retries, "retries", 0, "number of times to retry a file before moving it to the failed directory")
+	flags.DurationVar(&c.retryDelay, "retry-delay", time.Second, "time to wait before retrying a file")
+	flags.DurationVar(&c.timeout, "timeout", 0, "time after which the command is killed, or 0 for no limit")
+	flags.StringVar(&c.workDir, "work-dir", watchdir.DefaultHotFolderWorkDir, "`dir`ectory within DIR that files are moved to while they are processed")
+	flags.StringVar(&c.instance, "instance", watchdir.DefaultHotFolderInstance, "`name` of the directory within the work directory that this process claims files into. Processes sharing DIR must use different names")
+	flags.StringVar(&c.doneDir, "done-dir", watchdir.DefaultHotFolderDoneDir, "`dir`ectory within DIR that files are moved to after they are processed")
+	flags.StringVar(&c.failedDir, "failed-dir", watchdir.DefaultHotFolderFailedDir, "`dir`ectory within DIR that files are moved to if processing fails")
+}
+
+func (c *processConfig) validate() error {
+	if c.command == "" {
+		return errors.New("-command is required")
+	}
+	if err := validateCommand(c.command); err != nil {
+		return fmt.Errorf("-command: %w", err)
+	}
+	if c.workers < 1 {
+		return errors.New("-workers must be at least 1")
+	}
+	if c.retries < 0 {
+		return errors.New("-retries must not be negative")
+	}
+	if c.retryDelay < 0 {
+		return errors.New("-retry-delay must not be negative")
+	}
+	if c.timeout < 0 {
+		return errors.New("-timeout must not be negative")
+	}
+	return nil
+}
+
+// processResult describes the outcome of processing a file.
+type processResult struct {
+	Type     string        `json:"type"`
+	Path     string        `json:"path"`
+	Dest     string        `json:"dest"`
+	Attempts int           `json:"attempts"`
+	Duration time.Duration `json:"duration_ns"`
+	Error    string        `json:"error,omitempty"`
+	Time     time.Time     `json:"timestamp"`
+}
+
+// processMain runs the command for each file dropped into a directory, moving the file aside once it is processed.
+func processMain(args []string) {
+	var watch watchConfig
+	var out outputConfig
+	var proc processConfig
+	flags := flag.NewFlagSet("watchdir process", flag.ExitOnError)
+	watch.register(flags)
+	out.register(flags)
+	proc.register(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s -command COMMAND [flags] DIR\n\nProcesses each file dropped into DIR by running COMMAND. Files are claimed by moving them to the work directory,\nthen moved to the done or failed directory once the command has finished.\n\nFlags:\n", flags.Name())
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	// Validate the arguments before doing anything
+	if flags.NArg() != 1 {
+		usageError(flags, errors.New("please provide a single directory argument"))
+	}
+	for _, cfg := range []validator{&watch, &out, &proc} {
+		if err := cfg.validate(); err != nil {
+			usageError(flags, err)
+		}
+	}
+	dir := flags.Arg(0)
+
+	// Results are printed to stdout in JSON mode, and all diagnostics are logged to stderr
+	level, _ := parseLogLevel(watch.LogLevel)
+	logs := newLogger(os.Stderr, level)
+	printer, _ := out.printer(os.Stdout)
+
+	hot, err := newProcessHotFolder(dir, &watch, &proc, logs, func(result watchdir.HotFolderResult) {
+		reportProcess(printer, logs, result)
+	})
+	if err != nil {
+		usageError(flags, err)
+	}
+
+	// Create a context that is cancelled on SIGINT/SIGTERM (Ctrl+C)
+	ctx := context.Background()
+	ctx, cancel := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	logs.Infof("processing files in %s", dir)
+	if err := hot.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		logs.Errorf("%v", err)
+		os.Exit(1)
+	}
+}
+
+// newProcessHotFolder creates the hot folder that runs the command for each file dropped into dir. It must only be
+// called after the configs have been validated.
+func newProcessHotFolder(dir string, watch *watchConfig, proc *processConfig, logs *logger, report func(watchdir.HotFolderResult)) (*watchdir.HotFolder, error) {
+	runner := &executor{
+		cfg:    &execConfig{Timeout: proc.timeout},
+		dir:    filepath.Join(dir, filepath.FromSlash(proc.workDir), proc.instance),
+		stderr: os.Stderr,
+	}
+	return watchdir.NewHotFolder(watchdir.HotFolderConfig{
+		Dir: dir,
+		Processor: watchdir.ProcessorFunc(func(ctx context.Context, file watchdir.HotFile) error {
+			// The command is run against the claimed file, which may have been renamed if the name was taken
+			event := watchdir.Event{Type: watchdir.FileAdded, File: file.Name}
+			return runner.executeFile(ctx, proc.command, event, file.Path)
+		}),
+		WorkDir:    proc.workDir,
+		Instance:   proc.instance,
+		DoneDir:    proc.doneDir,
+		FailedDir:  proc.failedDir,
+		Workers:    proc.workers,
+		Retries:    proc.retries,
+		RetryDelay: proc.retryDelay,
+		Interval:   watch.Interval,
+		Options:    watch.hotFolderOptions(dir, logs),
+		DirFilter:  watch.dirFilter(),
+		Report:     report,
+		Logger:     logs.log,
+	})
+}
+
+// reportProcess prints the outcome of processing a file in JSON mode, and logs it otherwise.
+func reportProcess(printer *eventPrinter, logs *logger, result watchdir.HotFolderResult) {
+	record := processResult{
+		Type:     "process",
+		Path:     result.Name,
+		Dest:     result.Dest,
+		Attempts: result.Attempts,
+		Duration: result.Duration,
+		Time:     time.Now(),
+	}
+	if result.Err != nil {
+		record.Error = result.Err.Error()
+	}
+	if ok, err := printer.printJSON(record); ok {
+		if err != nil {
+			logs.Errorf("print process result: %v", err)
+		}
+		return
+	}
+	if result.Err != nil {
+		logs.Errorf("processing %s failed after %d attempts, moved to %s: %v", result.Name, result.Attempts, result.Dest, result.Err)
+	} else {
+		logs.Infof("processed %s, moved to %s", result.Name, result.Dest)
+	}
+}
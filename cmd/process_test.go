@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spiretechnology/go-watchdir/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, name, content string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(name), 0o755), "error creating directory")
+	require.NoError(t, os.WriteFile(name, []byte(content), 0o644), "error writing file")
+}
+
+// startProcess runs the hot folder for the process command on a directory, copying each file into out, and returns the
+// channel that receives the outcome of each file.
+func startProcess(t *testing.T, dir, out string, watch watchConfig) <-chan watchdir.HotFolderResult {
+	t.Helper()
+	var proc processConfig
+	proc.register(flag.NewFlagSet("", flag.ContinueOnError))
+	proc.command = "cp {} " + out
+	watch.Stability = 0
+	watch.Interval = 10 * time.Millisecond
+	for _, cfg := range []validator{&watch, &proc} {
+		require.NoError(t, cfg.validate(), "config should be valid")
+	}
+
+	results := make(chan watchdir.HotFolderResult, 10)
+	hot, err := newProcessHotFolder(dir, &watch, &proc, newLogger(io.Discard, levelError), func(result watchdir.HotFolderResult) {
+		results <- result
+	})
+	require.NoError(t, err, "error creating hot folder")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	chanErr := make(chan error, 1)
+	go func() {
+		chanErr <- hot.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-chanErr
+	})
+	return results
+}
+
+func waitProcessed(t *testing.T, results <-chan watchdir.HotFolderResult) watchdir.HotFolderResult {
+	t.Helper()
+	select {
+	case result := <-results:
+		return result
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the file to be processed")
+		return watchdir.HotFolderResult{}
+	}
+}
+
+func TestProcess(t *testing.T) {
+	t.Run("runs the command for files outside excluded directories", func(t *testing.T) {
+		dir, out := t.TempDir(), t.TempDir()
+		writeFile(t, filepath.Join(dir, "in", "report.csv"), "report")
+		writeFile(t, filepath.Join(dir, "skip", "other.csv"), "other")
+
+		watch := defaultWatchConfig()
+		require.NoError(t, watch.Exclude.Set("skip"), "error setting pattern")
+		result := waitProcessed(t, startProcess(t, dir, out, watch))
+		require.NoError(t, result.Err, "command should succeed")
+		require.Equal(t, "in/report.csv", result.Name, "wrong file processed")
+		require.Equal(t, "done/in/report.csv", result.Dest, "wrong destination")
+
+		data, err := os.ReadFile(filepath.Join(out, "report.csv"))
+		require.NoError(t, err, "command should have copied the file")
+		require.Equal(t, "report", string(data), "wrong file copied")
+		require.FileExists(t, filepath.Join(dir, "skip", "other.csv"), "excluded file should be left alone")
+	})
+	t.Run("runs the command on a claimed file that was renamed", func(t *testing.T) {
+		dir, out := t.TempDir(), t.TempDir()
+		writeFile(t, filepath.Join(dir, "ready.txt"), "ready")
+		results := startProcess(t, dir, out, defaultWatchConfig())
+		waitProcessed(t, results)
+
+		// A file with the same name is still being processed, so the claim is renamed to report-1.csv
+		writeFile(t, filepath.Join(dir, watchdir.DefaultHotFolderWorkDir, watchdir.DefaultHotFolderInstance, "report.csv"), "other")
+		writeFile(t, filepath.Join(dir, "report.csv"), "mine")
+		result := waitProcessed(t, results)
+		require.NoError(t, result.Err, "command should succeed")
+
+		data, err := os.ReadFile(filepath.Join(out, "report-1.csv"))
+		require.NoError(t, err, "command should have copied the claimed file")
+		require.Equal(t, "mine", string(data), "wrong file copied")
+		require.NoFileExists(t, filepath.Join(out, "report.csv"), "the other file should not be touched")
+	})
+}
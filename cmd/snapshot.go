@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/spiretechnology/go-watchdir/v2"
+)
+
+// snapshotMain walks a directory once and writes a manifest of its files.
+func snapshotMain(args []string) {
+	var cfg watchConfig
+	var outPath string
+	flags := flag.NewFlagSet("watchdir snapshot", flag.ExitOnError)
+	cfg.register(flags)
+	flags.StringVar(&outPath, "o", "", "`file` to write the manifest to, instead of stdout")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [flags] DIR\n\nWalks DIR once, using the same traversal and filters as the watcher, and writes a manifest of its files.\n\nFlags:\n", flags.Name())
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	// Validate the arguments before doing anything
+	if flags.NArg() != 1 {
+		usageError(flags, errors.New("please provide a single directory argument"))
+	}
+	if err := cfg.validate(); err != nil {
+		usageError(flags, err)
+	}
+	level, _ := parseLogLevel(cfg.LogLevel)
+	logs := newLogger(os.Stderr, level)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	snap, err := walkDir(ctx, flags.Arg(0), &cfg, logs)
+	if err != nil {
+		logs.Errorf("%v", err)
+		os.Exit(1)
+	}
+	if err := writeManifest(outPath, snap); err != nil {
+		logs.Errorf("%v", err)
+		os.Exit(1)
+	}
+}
+
+// diffMain compares a manifest against another manifest or a live directory, and prints the differences as events.
+func diffMain(args []string) {
+	var cfg watchConfig
+	var out outputConfig
+	flags := flag.NewFlagSet("watchdir diff", flag.ExitOnError)
+	cfg.register(flags)
+	out.register(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [flags] MANIFEST MANIFEST|DIR\n\nPrints the files that were added, removed or modified between the first manifest and the second manifest, or the\ncurrent contents of DIR. DIR is walked with the same traversal and filters as the watcher.\n\nFlags:\n", flags.Name())
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	// Validate the arguments before doing anything
+	if flags.NArg() != 2 {
+		usageError(flags, errors.New("please provide a manifest and a manifest or directory to compare it to"))
+	}
+	for _, c := range []validator{&cfg, &out} {
+		if err := c.validate(); err != nil {
+			usageError(flags, err)
+		}
+	}
+	level, _ := parseLogLevel(cfg.LogLevel)
+	logs := newLogger(os.Stderr, level)
+	printer, _ := out.printer(os.Stdout)
+	mask, _ := parseEventMask(cfg.Events)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	before, err := readManifest(flags.Arg(0))
+	if err != nil {
+		logs.Errorf("%v", err)
+		os.Exit(1)
+	}
+	after, err := readManifestOrDir(ctx, flags.Arg(1), &cfg, logs)
+	if err != nil {
+		logs.Errorf("%v", err)
+		os.Exit(1)
+	}
+
+	for _, event := range watchdir.Diff(before, after) {
+		if event.Type&mask == 0 {
+			continue
+		}
+		if err := printer.print(newDiffRecord(after, event)); err != nil {
+			logs.Errorf("print event: %v", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// walkDir sweeps a directory once with the configured traversal and filters, and returns the files it found. Every file
+// is included as it is, even if the watcher would hold it back because it was modified recently or is still waiting
+// for a marker, so that the manifest reflects the whole directory.
+func walkDir(ctx context.Context, dir string, cfg *watchConfig, logs *logger) (watchdir.Snapshot, error) {
+	options := append(cfg.traversalOptions(logs), watchdir.WithWriteStabilityThreshold(0))
+	wd := watchdir.NewDirWatcher(os.DirFS(dir), options...)
+	if err := wd.Baseline(ctx); err != nil {
+		return watchdir.Snapshot{}, fmt.Errorf("walk %s: %w", dir, err)
+	}
+	return wd.Snapshot(ctx)
+}
+
+// readManifestOrDir reads a manifest, or walks the path if it is a directory.
+func readManifestOrDir(ctx context.Context, name string, cfg *watchConfig, logs *logger) (watchdir.Snapshot, error) {
+	stat, err := os.Stat(name)
+	if err != nil {
+		return watchdir.Snapshot{}, err
+	}
+	if stat.IsDir() {
+		return walkDir(ctx, name, cfg, logs)
+	}
+	return readManifest(name)
+}
+
+// readManifest reads a manifest written by the snapshot command.
+func readManifest(name string) (watchdir.Snapshot, error) {
+	var snap watchdir.Snapshot
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return snap, fmt.Errorf("read manifest: %w", err)
+	}
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return snap, fmt.Errorf("parse manifest %s: %w", name, err)
+	}
+	return snap, nil
+}
+
+// writeManifest writes a snapshot to a file, or to stdout if the name is empty. The file is replaced atomically, so a
+// failed write never leaves a truncated manifest behind.
+func writeManifest(name string, snap watchdir.Snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("encode manifest: %w", err)
+	}
+	data = append(data, '\n')
+	if name == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	return nil
+}
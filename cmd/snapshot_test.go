@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spiretechnology/go-watchdir/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalkDir(t *testing.T) {
+	t.Run("includes files the watcher would hold back", func(t *testing.T) {
+		dir := t.TempDir()
+		logs := newLogger(io.Discard, levelError)
+		cfg := defaultWatchConfig()
+		cfg.MarkerSuffix = ".done"
+		require.NoError(t, cfg.validate(), "config should be valid")
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "old"), []byte("old"), 0o644), "error writing file")
+		before, err := walkDir(context.Background(), dir, &cfg, logs)
+		require.NoError(t, err, "error walking directory")
+
+		// A file written just now, without a marker, is still in the manifest
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "old"), []byte("changed"), 0o644), "error writing file")
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "new"), []byte("new"), 0o644), "error writing file")
+		after, err := walkDir(context.Background(), dir, &cfg, logs)
+		require.NoError(t, err, "error walking directory")
+		require.Equal(t, []watchdir.Event{
+			{Type: watchdir.FileAdded, File: "new"},
+			{Type: watchdir.FileModified, File: "old"},
+		}, watchdir.Diff(before, after), "wrong differences")
+	})
+}
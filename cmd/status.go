@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spiretechnology/go-watchdir/v2"
+)
+
+// statusConfig holds the flags that configure the health, status and metrics endpoints.
+type statusConfig struct {
+	listen          string
+	healthIntervals int
+}
+
+func (c *statusConfig) register(flags *flag.FlagSet) {
+	flags.StringVar(&c.listen, "listen", "", "`address` to serve /healthz, /status and /metrics on, e.g. :8080")
+	flags.IntVar(&c.healthIntervals, "health-intervals", 3, "number of sweep intervals without a successful sweep before /healthz fails")
+}
+
+func (c *statusConfig) validate() error {
+	if c.listen == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(c.listen); err != nil {
+		return fmt.Errorf("-listen: %w", err)
+	}
+	if c.healthIntervals < 1 {
+		return errors.New("-health-intervals must be at least 1")
+	}
+	return nil
+}
+
+// enabled returns true if the endpoints should be served.
+func (c *statusConfig) enabled() bool {
+	return c.listen != ""
+}
+
+// serve runs the HTTP server until the context is cancelled.
+func (c *statusConfig) serve(ctx context.Context, handler http.Handler, logs *logger) error {
+	listener, err := net.Listen("tcp", c.listen)
+	if err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	logs.Infof("serving status on %s", listener.Addr())
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// monitor wraps a watcher to record the outcome of each sweep, along with the events delivered by its job.
+type monitor struct {
+	watchdir.DirWatcher
+	name     string
+	dir      string
+	interval time.Duration
+	logs     *logger
+
+	mu           sync.Mutex
+	started      time.Time
+	sweeps       uint64
+	sweepErrors  uint64
+	lastSweep    time.Time
+	lastSuccess  time.Time
+	lastDuration time.Duration
+	lastErr      error
+	knownFiles   int
+	events       map[watchdir.EventType]uint64
+	exitErr      error
+}
+
+func newMonitor(wd watchdir.DirWatcher, name, dir string, interval time.Duration, logs *logger) *monitor {
+	return &monitor{
+		DirWatcher: wd,
+		name:       name,
+		dir:        dir,
+		interval:   interval,
+		logs:       logs,
+		started:    time.Now(),
+		knownFiles: -1,
+		events:     make(map[watchdir.EventType]uint64),
+	}
+}
+
+// Sweep performs a sweep with the wrapped watcher, and records how long it took, whether it failed, and how many files
+// are known once it completes, so that status requests don't need to inspect the watcher. Failures are also logged.
+func (m *monitor) Sweep(ctx context.Context, chanEvents chan<- watchdir.Event) error {
+	start := time.Now()
+	err := m.DirWatcher.Sweep(ctx, chanEvents)
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	// Failed sweeps are retried on the next interval, so this is the only place the error is seen
+	if err != nil {
+		m.logs.Errorf("sweep failed: %v", err)
+	}
+	knownFiles := m.DirWatcher.Len()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweeps++
+	if err != nil {
+		m.sweepErrors++
+	}
+	m.lastSweep = time.Now()
+	m.lastDuration = m.lastSweep.Sub(start)
+	m.lastErr = err
+	m.knownFiles = knownFiles
+	if err == nil {
+		m.lastSuccess = m.lastSweep
+	}
+	return err
+}
+
+// recordEvent counts an event that was delivered to the job's outputs.
+func (m *monitor) recordEvent(event watchdir.Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events[event.Type]++
+}
+
+// changes returns the number of events that were delivered.
+func (m *monitor) changes() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var total uint64
+	for _, count := range m.events {
+		total += count
+	}
+	return total
+}
+
+// recordExit marks the job as no longer running.
+func (m *monitor) recordExit(err error) {
+	if err == nil {
+		err = errors.New("job stopped")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.exitErr = err
+}
+
+// jobStatus is the state of a single job, as reported by /status.
+type jobStatus struct {
+	Name              string            `json:"name,omitempty"`
+	Dir               string            `json:"dir"`
+	Healthy           bool              `json:"healthy"`
+	Sweeps            uint64            `json:"sweeps"`
+	SweepErrors       uint64            `json:"sweep_errors"`
+	LastSweep         *time.Time        `json:"last_sweep,omitempty"`
+	LastSweepDuration time.Duration     `json:"last_sweep_duration_ns"`
+	LastError         string            `json:"last_error,omitempty"`
+	KnownFiles        int               `json:"known_files"`
+	Events            map[string]uint64 `json:"events"`
+}
+
+// status returns the current state of the job. A job is healthy while it is running and has completed a sweep without
+// errors within the last few intervals, or has only just started.
+func (m *monitor) status(now time.Time, healthIntervals int) jobStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status := jobStatus{
+		Name:              m.name,
+		Dir:               m.dir,
+		Sweeps:            m.sweeps,
+		SweepErrors:       m.sweepErrors,
+		LastSweepDuration: m.lastDuration,
+		KnownFiles:        m.knownFiles,
+		Events:            make(map[string]uint64, len(m.events)),
+	}
+	for eventType, count := range m.events {
+		status.Events[eventType.String()] = count
+	}
+
+	if !m.lastSweep.IsZero() {
+		lastSweep := m.lastSweep
+		status.LastSweep = &lastSweep
+	}
+	since := m.started
+	if !m.lastSuccess.IsZero() {
+		since = m.lastSuccess
+	}
+	switch {
+	case m.exitErr != nil:
+		status.LastError = m.exitErr.Error()
+	case m.lastErr != nil:
+		status.LastError = m.lastErr.Error()
+	}
+	status.Healthy = m.exitErr == nil && now.Sub(since) <= time.Duration(healthIntervals)*m.interval
+	return status
+}
+
+// statusServer serves the health, status and metrics of a set of jobs. Jobs can be added and removed while it runs.
+type statusServer struct {
+	healthIntervals int
+
+	mu       sync.Mutex
+	monitors map[string]*monitor
+}
+
+func newStatusServer(cfg *statusConfig) *statusServer {
+	return &statusServer{
+		healthIntervals: cfg.healthIntervals,
+		monitors:        make(map[string]*monitor),
+	}
+}
+
+func (s *statusServer) add(m *monitor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.monitors[m.name] = m
+}
+
+func (s *statusServer) remove(m *monitor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.monitors[m.name] == m {
+		delete(s.monitors, m.name)
+	}
+}
+
+// statuses returns the status of every job, sorted by name.
+func (s *statusServer) statuses() []jobStatus {
+	s.mu.Lock()
+	monitors := make([]*monitor, 0, len(s.monitors))
+	for _, m := range s.monitors {
+		monitors = append(monitors, m)
+	}
+	s.mu.Unlock()
+
+	now := time.Now()
+	statuses := make([]jobStatus, 0, len(monitors))
+	for _, m := range monitors {
+		statuses = append(statuses, m.status(now, s.healthIntervals))
+	}
+	slices.SortFunc(statuses, func(a, b jobStatus) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return statuses
+}
+
+func (s *statusServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.serveHealth)
+	mux.HandleFunc("GET /status", s.serveStatus)
+	mux.HandleFunc("GET /metrics", s.serveMetrics)
+	return mux
+}
+
+// serveHealth responds with 200 if every job is healthy, and 503 otherwise.
+func (s *statusServer) serveHealth(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, status := range s.statuses() {
+		if !status.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			if status.Name != "" {
+				fmt.Fprintf(w, "job %q has not completed a successful sweep recently\n", status.Name)
+			} else {
+				fmt.Fprintln(w, "no successful sweep has completed recently")
+			}
+			return
+		}
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (s *statusServer) serveStatus(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		Jobs []jobStatus `json:"jobs"`
+	}{s.statuses()})
+}
+
+// serveMetrics writes the metrics in the Prometheus text exposition format.
+func (s *statusServer) serveMetrics(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	statuses := s.statuses()
+
+	metric := func(name, kind, help string, value func(status jobStatus) (float64, bool)) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, status := range statuses {
+			if v, ok := value(status); ok {
+				fmt.Fprintf(w, "%s%s %v\n", name, metricLabels("job", status.Name), v)
+			}
+		}
+	}
+	metric("watchdir_up", "gauge", "Whether the job is healthy.", func(status jobStatus) (float64, bool) {
+		if status.Healthy {
+			return 1, true
+		}
+		return 0, true
+	})
+	metric("watchdir_sweeps_total", "counter", "Number of completed sweeps.", func(status jobStatus) (float64, bool) {
+		return float64(status.Sweeps), true
+	})
+	metric("watchdir_sweep_errors_total", "counter", "Number of sweeps that failed.", func(status jobStatus) (float64, bool) {
+		return float64(status.SweepErrors), true
+	})
+	metric("watchdir_last_sweep_timestamp_seconds", "gauge", "Time the last sweep completed, in seconds since the epoch.", func(status jobStatus) (float64, bool) {
+		if status.LastSweep == nil {
+			return 0, false
+		}
+		return float64(status.LastSweep.UnixNano()) / 1e9, true
+	})
+	metric("watchdir_last_sweep_duration_seconds", "gauge", "Duration of the last sweep.", func(status jobStatus) (float64, bool) {
+		return status.LastSweepDuration.Seconds(), status.LastSweep != nil
+	})
+	metric("watchdir_known_files", "gauge", "Number of files known to the watcher.", func(status jobStatus) (float64, bool) {
+		return float64(status.KnownFiles), status.KnownFiles >= 0
+	})
+
+	fmt.Fprint(w, "# HELP watchdir_events_total Number of events delivered, by type.\n# TYPE watchdir_events_total counter\n")
+	for _, status := range statuses {
+		// Added and removed files are always exported so their series exist from the start, along with every other type
+		// that has been recorded
+		types := []string{watchdir.FileAdded.String(), watchdir.FileRemoved.String()}
+		for eventType := range status.Events {
+			if !slices.Contains(types, eventType) {
+				types = append(types, eventType)
+			}
+		}
+		slices.Sort(types)
+		for _, eventType := range types {
+			fmt.Fprintf(w, "watchdir_events_total%s %d\n", metricLabels("job", status.Name, "type", eventType), status.Events[eventType])
+		}
+	}
+}
+
+// metricLabels formats pairs of label names and values, skipping any that are empty.
+func metricLabels(pairs ...string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] == "" {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, "%s=%q", pairs[i], pairs[i+1])
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return "{" + b.String() + "}"
+}
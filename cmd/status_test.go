@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spiretechnology/go-watchdir/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// countingWatcher counts how often the monitor inspects it.
+type countingWatcher struct {
+	watchdir.DirWatcher
+	snapshots int
+	lens      int
+}
+
+func (w *countingWatcher) Sweep(ctx context.Context, chanEvents chan<- watchdir.Event) error {
+	return nil
+}
+
+func (w *countingWatcher) Snapshot(ctx context.Context) (watchdir.Snapshot, error) {
+	w.snapshots++
+	return watchdir.NewSnapshot(watchdir.SnapshotEntry{Path: "foo"}, watchdir.SnapshotEntry{Path: "bar"}), nil
+}
+
+func (w *countingWatcher) Len() int {
+	w.lens++
+	return 2
+}
+
+func TestMonitor(t *testing.T) {
+	t.Run("counts known files once per sweep", func(t *testing.T) {
+		wd := &countingWatcher{}
+		m := newMonitor(wd, "job", "/srv", time.Minute, newLogger(io.Discard, levelError))
+		require.Equal(t, -1, m.status(time.Now(), 3).KnownFiles, "known files should be unknown before a sweep")
+
+		require.NoError(t, m.Sweep(context.Background(), nil), "error sweeping")
+		for range 3 {
+			status := m.status(time.Now(), 3)
+			require.Equal(t, 2, status.KnownFiles, "wrong number of known files")
+			require.True(t, status.Healthy, "job should be healthy")
+		}
+		require.Equal(t, 1, wd.lens, "status requests should not inspect the watcher")
+		require.Zero(t, wd.snapshots, "sweeps should not take a snapshot to count files")
+	})
+	t.Run("logs failed sweeps whatever the library log level", func(t *testing.T) {
+		var out bytes.Buffer
+		missing := filepath.Join(t.TempDir(), "missing")
+		m := newMonitor(watchdir.NewDirWatcher(os.DirFS(missing)), "job", missing, time.Minute, newLogger(&out, levelError))
+		require.Error(t, m.Sweep(context.Background(), nil), "sweeping a missing directory should fail")
+		require.Contains(t, out.String(), "sweep failed", "the failure should be logged")
+	})
+	t.Run("exports every recorded event type", func(t *testing.T) {
+		m := newMonitor(&countingWatcher{}, "job", "/srv", time.Minute, newLogger(io.Discard, levelError))
+		m.recordEvent(watchdir.Event{Type: watchdir.FileAdded, File: "foo"})
+		m.recordEvent(watchdir.Event{Type: watchdir.FileTimeout, File: "bar"})
+		m.recordEvent(watchdir.Event{Type: watchdir.BundleReady, File: "baz"})
+		server := newStatusServer(&statusConfig{healthIntervals: 3})
+		server.add(m)
+
+		rec := httptest.NewRecorder()
+		server.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		require.Equal(t, http.StatusOK, rec.Code, "wrong status code")
+		for eventType, count := range map[watchdir.EventType]int{
+			watchdir.FileAdded:   1,
+			watchdir.FileRemoved: 0,
+			watchdir.FileTimeout: 1,
+			watchdir.BundleReady: 1,
+		} {
+			line := fmt.Sprintf("watchdir_events_total{job=%q,type=%q} %d\n", "job", eventType.String(), count)
+			require.Contains(t, rec.Body.String(), line, "missing event count")
+		}
+	})
+}
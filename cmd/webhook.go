@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/spiretechnology/go-watchdir/v2"
+)
+
+// webhookConfig holds the flags that configure delivery of events to a webhook.
+type webhookConfig struct {
+	URL           string        `yaml:"url"`
+	Secret        string        `yaml:"secret"`
+	SpoolDir      string        `yaml:"spool"`
+	SpoolLimit    int           `yaml:"spool_limit"`
+	BatchSize     int           `yaml:"batch_size"`
+	BatchInterval time.Duration `yaml:"batch_interval"`
+	Timeout       time.Duration `yaml:"timeout"`
+	Attempts      int           `yaml:"attempts"`
+}
+
+// onceWebhookAttempts is the number of delivery attempts for each batch in one-shot mode, unless -webhook-attempts is
+// set, so that a run against an endpoint that is down exits instead of retrying forever.
+const onceWebhookAttempts = 3
+
+func (c *webhookConfig) register(flags *flag.FlagSet) {
+	flags.StringVar(&c.URL, "webhook", "", "`url` to POST batches of events to as JSON")
+	flags.StringVar(&c.Secret, "webhook-secret", os.Getenv("WATCHDIR_WEBHOOK_SECRET"), "key used to sign webhook requests with HMAC-SHA256 (defaults to $WATCHDIR_WEBHOOK_SECRET)")
+	flags.StringVar(&c.SpoolDir, "webhook-spool", "", "`dir`ectory where undelivered webhook batches are stored, so they survive restarts")
+	flags.IntVar(&c.SpoolLimit, "webhook-spool-limit", watchdir.DefaultWebhookSpoolLimit, "maximum number of undelivered webhook batches")
+	flags.IntVar(&c.BatchSize, "webhook-batch-size", watchdir.DefaultWebhookBatchSize, "maximum number of events in a webhook request")
+	flags.DurationVar(&c.BatchInterval, "webhook-batch-interval", watchdir.DefaultWebhookBatchInterval, "maximum time an event waits for its webhook batch to fill")
+	flags.DurationVar(&c.Timeout, "webhook-timeout", watchdir.DefaultWebhookTimeout, "maximum time for a webhook request before it is retried")
+	flags.IntVar(&c.Attempts, "webhook-attempts", 0, "maximum number of attempts to deliver a webhook batch before failing, or 0 to retry forever (3 with -once)")
+}
+
+func (c *webhookConfig) validate() error {
+	if c.URL == "" {
+		return nil
+	}
+	if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return errors.New("-webhook must be an http or https url")
+	}
+	if c.SpoolLimit < 1 {
+		return errors.New("-webhook-spool-limit must be at least 1")
+	}
+	if c.BatchSize < 1 {
+		return errors.New("-webhook-batch-size must be at least 1")
+	}
+	if c.BatchInterval <= 0 {
+		return errors.New("-webhook-batch-interval must be positive")
+	}
+	if c.Timeout <= 0 {
+		return errors.New("-webhook-timeout must be positive")
+	}
+	if c.Attempts < 0 {
+		return errors.New("-webhook-attempts must not be negative")
+	}
+	return nil
+}
+
+// enabled returns true if a webhook is configured.
+func (c *webhookConfig) enabled() bool {
+	return c.URL != ""
+}
+
+// sink creates the webhook sink. In one-shot mode, delivery attempts are always bounded, so the run can fail.
+func (c *webhookConfig) sink(logs *logger, once bool) (*watchdir.WebhookSink, error) {
+	attempts := c.Attempts
+	if once && attempts == 0 {
+		attempts = onceWebhookAttempts
+	}
+	return watchdir.NewWebhookSink(watchdir.WebhookConfig{
+		URL:           c.URL,
+		Secret:        []byte(c.Secret),
+		BatchSize:     c.BatchSize,
+		BatchInterval: c.BatchInterval,
+		Timeout:       c.Timeout,
+		SpoolDir:      c.SpoolDir,
+		SpoolLimit:    c.SpoolLimit,
+		MaxAttempts:   attempts,
+		Logger:        logs.log,
+	})
+}
@@ -0,0 +1,149 @@
+package watchdir
+
+import (
+	"context"
+	"time"
+)
+
+// Coalesce reads events from in and forwards them to out once they have been held for the given window. While an event
+// is held, a FileRemoved for the same path cancels out a pending FileAdded, and repeated events of the same type for
+// the same path are collapsed into one. Events for different paths are forwarded in the order they were first received.
+//
+// When in is closed, all pending events are flushed and Coalesce returns nil. When the context is cancelled, pending
+// events are still flushed, but only for up to a second so that a consumer that has stopped reading can't block
+// Coalesce, and then the context error is returned.
+func Coalesce(ctx context.Context, window time.Duration, in <-chan Event, out chan<- Event) error {
+	c := coalescer{
+		window:  window,
+		pending: make(map[string]*pendingPath),
+	}
+
+	timer := time.NewTimer(window)
+	defer timer.Stop()
+
+	for {
+		// Wait for the oldest pending path to become due, if there is one
+		var chanTimer <-chan time.Time
+		if next := c.front(); next != nil {
+			timer.Reset(time.Until(next.deadline))
+			chanTimer = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			return c.flushCancelled(ctx, out)
+		case event, ok := <-in:
+			if !ok {
+				if err := c.flush(ctx, out); err != nil {
+					return c.flushCancelled(ctx, out)
+				}
+				return nil
+			}
+			c.add(event, time.Now())
+		case now := <-chanTimer:
+			if err := c.flushDue(ctx, out, now); err != nil {
+				return c.flushCancelled(ctx, out)
+			}
+		}
+	}
+}
+
+// coalesceCancelFlushTimeout is the longest Coalesce spends flushing pending events once its context is cancelled.
+const coalesceCancelFlushTimeout = time.Second
+
+// pendingPath holds the events for a single path that have not yet been forwarded.
+type pendingPath struct {
+	file     string
+	events   []Event
+	deadline time.Time
+}
+
+type coalescer struct {
+	window  time.Duration
+	pending map[string]*pendingPath
+	queue   []*pendingPath
+}
+
+func (c *coalescer) add(event Event, now time.Time) {
+	// If nothing is pending for this path, start holding the event
+	p := c.pending[event.File]
+	if p == nil {
+		p = &pendingPath{
+			file:     event.File,
+			events:   []Event{event},
+			deadline: now.Add(c.window),
+		}
+		c.pending[event.File] = p
+		c.queue = append(c.queue, p)
+		return
+	}
+
+	last := p.events[len(p.events)-1]
+	switch {
+	case last.Type == event.Type:
+		// Collapse repeated events into the most recent one
+		p.events[len(p.events)-1] = event
+	case last.Type == FileAdded && event.Type == FileRemoved:
+		// The file came and went within the window, so neither event is forwarded
+		p.events = p.events[:len(p.events)-1]
+		if len(p.events) == 0 {
+			delete(c.pending, event.File)
+		}
+	default:
+		p.events = append(p.events, event)
+	}
+}
+
+// front returns the oldest pending path, discarding queue entries whose events have been cancelled out.
+func (c *coalescer) front() *pendingPath {
+	for len(c.queue) > 0 {
+		if p := c.queue[0]; c.pending[p.file] == p {
+			return p
+		}
+		c.queue = c.queue[1:]
+	}
+	return nil
+}
+
+// flushDue forwards the events for all paths whose deadline has passed.
+func (c *coalescer) flushDue(ctx context.Context, out chan<- Event, now time.Time) error {
+	for p := c.front(); p != nil && !p.deadline.After(now); p = c.front() {
+		if err := c.forward(ctx, out, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush forwards all pending events regardless of their deadline.
+func (c *coalescer) flush(ctx context.Context, out chan<- Event) error {
+	for p := c.front(); p != nil; p = c.front() {
+		if err := c.forward(ctx, out, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flushCancelled makes a best effort to forward the pending events after the context is cancelled, and returns the
+// context error.
+func (c *coalescer) flushCancelled(ctx context.Context, out chan<- Event) error {
+	flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), coalesceCancelFlushTimeout)
+	defer cancel()
+	_ = c.flush(flushCtx, out)
+	return ctx.Err()
+}
+
+// forward sends the events for a path, unless the context is cancelled first.
+func (c *coalescer) forward(ctx context.Context, out chan<- Event, p *pendingPath) error {
+	for len(p.events) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case out <- p.events[0]:
+			p.events = p.events[1:]
+		}
+	}
+	delete(c.pending, p.file)
+	return nil
+}
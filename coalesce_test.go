@@ -0,0 +1,117 @@
+package watchdir_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/spiretechnology/go-watchdir/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func coalesceEvents(t *testing.T, ctx context.Context, window time.Duration, events ...watchdir.Event) ([]watchdir.Event, error) {
+	t.Helper()
+
+	in := make(chan watchdir.Event, len(events))
+	for _, event := range events {
+		in <- event
+	}
+	close(in)
+
+	out := make(chan watchdir.Event, len(events))
+	err := watchdir.Coalesce(ctx, window, in, out)
+	close(out)
+
+	var result []watchdir.Event
+	for event := range out {
+		result = append(result, event)
+	}
+	return result, err
+}
+
+func TestCoalesce(t *testing.T) {
+	t.Run("cancels out added and removed pairs", func(t *testing.T) {
+		events, err := coalesceEvents(t, context.Background(), time.Minute,
+			watchdir.Event{Type: watchdir.FileAdded, File: "foo"},
+			watchdir.Event{Type: watchdir.FileAdded, File: "bar"},
+			watchdir.Event{Type: watchdir.FileRemoved, File: "foo"},
+		)
+		require.NoError(t, err, "error coalescing")
+		require.Equal(t, []watchdir.Event{
+			{Type: watchdir.FileAdded, File: "bar"},
+		}, events, "wrong events")
+	})
+	t.Run("collapses repeated events and keeps replacements", func(t *testing.T) {
+		events, err := coalesceEvents(t, context.Background(), time.Minute,
+			watchdir.Event{Type: watchdir.FileAdded, File: "foo"},
+			watchdir.Event{Type: watchdir.FileRemoved, File: "bar"},
+			watchdir.Event{Type: watchdir.FileAdded, File: "foo"},
+			watchdir.Event{Type: watchdir.FileAdded, File: "bar"},
+		)
+		require.NoError(t, err, "error coalescing")
+		require.Equal(t, []watchdir.Event{
+			{Type: watchdir.FileAdded, File: "foo"},
+			{Type: watchdir.FileRemoved, File: "bar"},
+			{Type: watchdir.FileAdded, File: "bar"},
+		}, events, "wrong events")
+	})
+	t.Run("flushes pending events on context cancellation", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		in := make(chan watchdir.Event)
+		out := make(chan watchdir.Event)
+		chanErr := make(chan error, 1)
+		go func() {
+			chanErr <- watchdir.Coalesce(ctx, time.Minute, in, out)
+		}()
+
+		in <- watchdir.Event{Type: watchdir.FileAdded, File: "foo"}
+		in <- watchdir.Event{Type: watchdir.FileAdded, File: "bar"}
+		cancel()
+		require.Equal(t, watchdir.Event{Type: watchdir.FileAdded, File: "foo"}, <-out, "should flush pending events")
+		require.Equal(t, watchdir.Event{Type: watchdir.FileAdded, File: "bar"}, <-out, "should flush pending events")
+		require.ErrorIs(t, <-chanErr, context.Canceled)
+	})
+	t.Run("stops when the consumer stops reading", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// Nobody reads from out, so forwarding the due event blocks until the context is cancelled, and flushing it then
+		// gives up after a short time
+		in := make(chan watchdir.Event)
+		out := make(chan watchdir.Event)
+		chanErr := make(chan error, 1)
+		go func() {
+			chanErr <- watchdir.Coalesce(ctx, time.Millisecond, in, out)
+		}()
+		in <- watchdir.Event{Type: watchdir.FileAdded, File: "foo"}
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+
+		select {
+		case err := <-chanErr:
+			require.ErrorIs(t, err, context.Canceled)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "coalesce did not stop")
+		}
+	})
+	t.Run("forwards events after the window", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		in := make(chan watchdir.Event)
+		out := make(chan watchdir.Event)
+		go func() {
+			_ = watchdir.Coalesce(ctx, 10*time.Millisecond, in, out)
+		}()
+
+		in <- watchdir.Event{Type: watchdir.FileAdded, File: "foo"}
+		select {
+		case event := <-out:
+			require.Equal(t, watchdir.Event{Type: watchdir.FileAdded, File: "foo"}, event, "wrong event")
+		case <-time.After(time.Second):
+			require.Fail(t, "event was not forwarded")
+		}
+	})
+}
@@ -6,6 +6,7 @@ require (
 	github.com/spiretechnology/go-memfs v1.1.0
 	github.com/stretchr/testify v1.8.4
 	golang.org/x/sync v0.16.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -13,5 +14,4 @@ require (
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/stretchr/objx v0.5.0 // indirect
 	golang.org/x/exp v0.0.0-20230811145659-89c5cff77bcb // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
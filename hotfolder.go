@@ -0,0 +1,339 @@
+package watchdir
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// DefaultHotFolderWorkDir is the default directory, relative to the hot folder, that files are moved to while they
+	// are processed.
+	DefaultHotFolderWorkDir = ".processing"
+
+	// DefaultHotFolderInstance is the default name of the directory, within the work directory, that a hot folder moves
+	// the files it claims to.
+	DefaultHotFolderInstance = "default"
+
+	// DefaultHotFolderDoneDir is the default directory, relative to the hot folder, that files are moved to after they
+	// are processed successfully.
+	DefaultHotFolderDoneDir = "done"
+
+	// DefaultHotFolderFailedDir is the default directory, relative to the hot folder, that files are moved to after
+	// processing fails.
+	DefaultHotFolderFailedDir = "failed"
+
+	// DefaultHotFolderInterval is the default time to wait between sweeps of the hot folder.
+	DefaultHotFolderInterval = 5 * time.Second
+)
+
+// Processor processes files claimed from a hot folder.
+type Processor interface {
+	// Process handles a single file. If it returns an error, the file is retried or moved to the failed directory. The
+	// file must not be moved or removed by the processor.
+	Process(ctx context.Context, file HotFile) error
+}
+
+type ProcessorFunc func(ctx context.Context, file HotFile) error
+
+func (f ProcessorFunc) Process(ctx context.Context, file HotFile) error {
+	return f(ctx, file)
+}
+
+// HotFile describes a file that has been claimed for processing.
+type HotFile struct {
+	// Name is the path of the file relative to the hot folder, in the same form as event paths.
+	Name string
+
+	// Path is the location of the claimed file in the work directory, in the form used by the os package.
+	Path string
+
+	// Attempt is the number of times processing has been attempted, including this one.
+	Attempt int
+}
+
+// HotFolderResult describes the outcome of processing a file.
+type HotFolderResult struct {
+	// Name is the path of the file relative to the hot folder.
+	Name string
+
+	// Dest is the path, relative to the hot folder, that the file was moved to after processing. If a file with the same
+	// name was already there, a counter is added to the name, such as "report-1.csv".
+	Dest string
+
+	// Attempts is the number of times processing was attempted.
+	Attempts int
+
+	// Duration is the time taken to process the file, including any retries.
+	Duration time.Duration
+
+	// Err is the error returned by the last attempt, or nil if the file was processed successfully.
+	Err error
+}
+
+// HotFolderConfig configures a HotFolder.
+type HotFolderConfig struct {
+	// Dir is the directory that files are dropped into.
+	Dir string
+
+	// Processor handles each file dropped into the directory.
+	Processor Processor
+
+	// WorkDir, DoneDir and FailedDir are the directories, relative to Dir, that files are moved to while they are
+	// processed, after they are processed successfully, and after processing fails. They are excluded from the sweep,
+	// and default to DefaultHotFolderWorkDir, DefaultHotFolderDoneDir and DefaultHotFolderFailedDir.
+	WorkDir   string
+	DoneDir   string
+	FailedDir string
+
+	// Instance identifies this hot folder among those sharing Dir. Files it claims are moved to a directory with this
+	// name within WorkDir, and only that directory is recovered when the hot folder starts, so hot folders sharing Dir
+	// must use different instances. It should stay the same across restarts, so that the files a previous run left
+	// behind are processed again. Defaults to DefaultHotFolderInstance.
+	Instance string
+
+	// Workers is the maximum number of files processed at the same time. Defaults to 1.
+	Workers int
+
+	// Retries is the number of times processing is retried after it fails, before the file is moved to FailedDir.
+	Retries int
+
+	// RetryDelay is the time to wait before retrying a file.
+	RetryDelay time.Duration
+
+	// Interval is the time to wait between sweeps. Defaults to DefaultHotFolderInterval.
+	Interval time.Duration
+
+	// Options configure the watcher used to find new files. They must not include WithDirFilter, since the hot folder
+	// sets its own directory filter; use DirFilter instead.
+	Options []Option
+
+	// DirFilter, if not nil, is consulted for directories other than the work, done and failed directories.
+	DirFilter Filter
+
+	// Report, if not nil, is called with the outcome of each file. It may be called concurrently by multiple workers.
+	Report func(HotFolderResult)
+
+	// Logger, if not nil, receives a line for each file that can't be claimed or moved.
+	Logger *log.Logger
+}
+
+// HotFolder processes files as they are dropped into a directory. Each file is claimed by atomically renaming it into
+// the instance's own directory within the work directory, so it is only processed once even if several hot folders
+// share the directory, then moved to the done or failed directory once it has been processed.
+type HotFolder struct {
+	cfg HotFolderConfig
+}
+
+// NewHotFolder creates a hot folder.
+func NewHotFolder(cfg HotFolderConfig) (*HotFolder, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("hot folder directory is required")
+	}
+	if cfg.Processor == nil {
+		return nil, errors.New("hot folder processor is required")
+	}
+	if cfg.WorkDir == "" {
+		cfg.WorkDir = DefaultHotFolderWorkDir
+	}
+	if cfg.DoneDir == "" {
+		cfg.DoneDir = DefaultHotFolderDoneDir
+	}
+	if cfg.FailedDir == "" {
+		cfg.FailedDir = DefaultHotFolderFailedDir
+	}
+	for _, dir := range []*string{&cfg.WorkDir, &cfg.DoneDir, &cfg.FailedDir} {
+		*dir = normalizePath(filepath.ToSlash(*dir))
+		if *dir == "" || !fs.ValidPath(*dir) {
+			return nil, fmt.Errorf("hot folder directory %q must be a subdirectory", *dir)
+		}
+	}
+	if cfg.Instance == "" {
+		cfg.Instance = DefaultHotFolderInstance
+	}
+	if !fs.ValidPath(cfg.Instance) || strings.Contains(cfg.Instance, "/") || cfg.Instance == "." {
+		return nil, fmt.Errorf("hot folder instance %q must be a single path element", cfg.Instance)
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultHotFolderInterval
+	}
+	return &HotFolder{cfg: cfg}, nil
+}
+
+// Run processes files until the context is cancelled. Files that are still being processed when the context is
+// cancelled, or that were left in the instance's claim directory by a previous run, are moved back so they are
+// processed again.
+func (h *HotFolder) Run(ctx context.Context) error {
+	for _, dir := range []string{h.claimDir(), h.cfg.DoneDir, h.cfg.FailedDir} {
+		if err := os.MkdirAll(h.osPath(dir, ""), 0o755); err != nil {
+			return fmt.Errorf("create hot folder directory: %w", err)
+		}
+	}
+	if err := h.recover(); err != nil {
+		return fmt.Errorf("recover claimed files: %w", err)
+	}
+
+	excluded := map[string]bool{h.cfg.WorkDir: true, h.cfg.DoneDir: true, h.cfg.FailedDir: true}
+	options := append(slices.Clip(h.cfg.Options), WithDirFilter(FilterFunc(func(ctx context.Context, dir string) (bool, error) {
+		if excluded[dir] {
+			return false, nil
+		}
+		if h.cfg.DirFilter != nil {
+			return h.cfg.DirFilter.Filter(ctx, dir)
+		}
+		return true, nil
+	})))
+	wd := NewDirWatcher(os.DirFS(h.cfg.Dir), options...)
+
+	eg, ctx := errgroup.WithContext(ctx)
+	chanEvents := make(chan Event)
+	eg.Go(func() error {
+		defer close(chanEvents)
+		return Watch(ctx, wd, h.cfg.Interval, chanEvents)
+	})
+	for range h.cfg.Workers {
+		eg.Go(func() error {
+			for event := range chanEvents {
+				if event.Type == FileAdded {
+					h.process(ctx, wd, event.File)
+				}
+			}
+			return nil
+		})
+	}
+	return eg.Wait()
+}
+
+// process claims a file, processes it, and moves it to the done or failed directory. Once the file is claimed, it is
+// forgotten by the watcher, so a new file dropped with the same name is reported even if no sweep sees it missing.
+func (h *HotFolder) process(ctx context.Context, wd DirWatcher, name string) {
+	src := h.osPath("", name)
+	work, err := moveFile(src, h.osPath(h.claimDir(), name))
+	if err != nil {
+		// If the file is gone, it was removed or claimed by someone else
+		if !errors.Is(err, fs.ErrNotExist) {
+			h.logf("claim %s: %v", name, err)
+		}
+		return
+	}
+	wd.Forget(name)
+
+	result := HotFolderResult{Name: name}
+	startTime := time.Now()
+	for {
+		result.Attempts++
+		err = h.cfg.Processor.Process(ctx, HotFile{Name: name, Path: work, Attempt: result.Attempts})
+		if err == nil || ctx.Err() != nil || result.Attempts > h.cfg.Retries {
+			break
+		}
+
+		// Wait before trying again
+		select {
+		case <-ctx.Done():
+		case <-time.After(h.cfg.RetryDelay):
+		}
+	}
+
+	// Release the claim if processing was interrupted, so the file is processed again by the next run
+	if err != nil && ctx.Err() != nil {
+		if _, err := moveFile(work, src); err != nil {
+			h.logf("release %s: %v", name, err)
+		}
+		return
+	}
+
+	destDir := h.cfg.DoneDir
+	if err != nil {
+		destDir = h.cfg.FailedDir
+	}
+	result.Duration = time.Since(startTime)
+	result.Err = err
+	dest, err := moveFile(work, h.osPath(destDir, name))
+	if err != nil {
+		h.logf("move %s to %s: %v", name, destDir, err)
+		dest = work
+	}
+	if rel, err := filepath.Rel(h.cfg.Dir, dest); err == nil {
+		result.Dest = filepath.ToSlash(rel)
+	}
+	if h.cfg.Report != nil {
+		h.cfg.Report(result)
+	}
+}
+
+// recover moves files left in the instance's claim directory by a previous run back into the hot folder. Files claimed
+// by other instances are left alone, since they may still be processing them.
+func (h *HotFolder) recover() error {
+	workDir := h.osPath(h.claimDir(), "")
+	return filepath.WalkDir(workDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(workDir, path)
+		if err != nil {
+			return err
+		}
+		_, err = moveFile(path, h.osPath("", filepath.ToSlash(rel)))
+		return err
+	})
+}
+
+// claimDir returns the directory, relative to the hot folder, that this instance moves the files it claims to.
+func (h *HotFolder) claimDir() string {
+	return path.Join(h.cfg.WorkDir, h.cfg.Instance)
+}
+
+// osPath returns the OS path of a file within one of the hot folder's directories.
+func (h *HotFolder) osPath(dir, name string) string {
+	return filepath.Join(h.cfg.Dir, filepath.FromSlash(dir), filepath.FromSlash(name))
+}
+
+func (h *HotFolder) logf(format string, args ...any) {
+	if h.cfg.Logger != nil {
+		h.cfg.Logger.Printf(format, args...)
+	}
+}
+
+// moveFile renames a file, creating the destination's parent directories if needed, and returns the path it was moved
+// to. An existing file is never replaced: if the destination is taken, a counter is added to the file's name, such as
+// "report-1.csv", until a free name is found. Each name is reserved by exclusively creating an empty file, which the
+// rename then replaces, so concurrent moves never pick the same name.
+func moveFile(src, dst string) (string, error) {
+	if _, err := os.Lstat(src); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return "", err
+	}
+	ext := filepath.Ext(dst)
+	stem := strings.TrimSuffix(dst, ext)
+	for i := 1; ; i++ {
+		f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err == nil {
+			f.Close()
+			break
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return "", err
+		}
+		dst = fmt.Sprintf("%s-%d%s", stem, i, ext)
+	}
+	if err := os.Rename(src, dst); err != nil {
+		_ = os.Remove(dst)
+		return "", err
+	}
+	return dst, nil
+}
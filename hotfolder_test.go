@@ -0,0 +1,300 @@
+package watchdir_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spiretechnology/go-watchdir/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// listFiles returns the slash-separated paths of the files within a directory.
+func listFiles(t *testing.T, dir string) []string {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		files = append(files, filepath.ToSlash(rel))
+		return err
+	})
+	if !errors.Is(err, os.ErrNotExist) {
+		require.NoError(t, err, "error listing files")
+	}
+	sort.Strings(files)
+	return files
+}
+
+func writeFile(t *testing.T, name, content string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(name), 0o755), "error creating directory")
+	require.NoError(t, os.WriteFile(name, []byte(content), 0o644), "error writing file")
+}
+
+func TestHotFolder(t *testing.T) {
+	t.Run("processes files and moves them to done or failed", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, "good.txt"), "good")
+		writeFile(t, filepath.Join(dir, "sub", "bad.txt"), "bad")
+		writeFile(t, filepath.Join(dir, "flaky.txt"), "flaky")
+
+		var mu sync.Mutex
+		attempts := make(map[string]int)
+		results := make(chan watchdir.HotFolderResult, 3)
+		hot, err := watchdir.NewHotFolder(watchdir.HotFolderConfig{
+			Dir: dir,
+			Processor: watchdir.ProcessorFunc(func(ctx context.Context, file watchdir.HotFile) error {
+				mu.Lock()
+				defer mu.Unlock()
+				attempts[file.Name]++
+
+				// The file has been claimed by moving it to the work directory
+				content, err := os.ReadFile(file.Path)
+				if err != nil {
+					return err
+				}
+				if string(content) == "bad" || (string(content) == "flaky" && file.Attempt == 1) {
+					return errors.New("processing failed")
+				}
+				return nil
+			}),
+			Workers:  2,
+			Retries:  1,
+			Interval: 10 * time.Millisecond,
+			Options:  []watchdir.Option{watchdir.WithWriteStabilityThreshold(0)},
+			Report: func(result watchdir.HotFolderResult) {
+				results <- result
+			},
+		})
+		require.NoError(t, err, "error creating hot folder")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		chanErr := make(chan error, 1)
+		go func() {
+			chanErr <- hot.Run(ctx)
+		}()
+
+		dests := make(map[string]string)
+		for range 3 {
+			select {
+			case result := <-results:
+				dests[result.Name] = result.Dest
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for files to be processed")
+			}
+		}
+		cancel()
+		require.ErrorIs(t, <-chanErr, context.Canceled)
+
+		require.Equal(t, map[string]string{
+			"good.txt":    "done/good.txt",
+			"flaky.txt":   "done/flaky.txt",
+			"sub/bad.txt": "failed/sub/bad.txt",
+		}, dests, "wrong destinations")
+		require.Equal(t, map[string]int{"good.txt": 1, "flaky.txt": 2, "sub/bad.txt": 2}, attempts, "wrong number of attempts")
+		require.Equal(t, []string{"done/flaky.txt", "done/good.txt", "failed/sub/bad.txt"}, listFiles(t, dir), "wrong files left")
+	})
+	t.Run("reprocesses files left in the work directory", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, watchdir.DefaultHotFolderWorkDir, watchdir.DefaultHotFolderInstance, "left.txt"), "left")
+
+		processed := make(chan string, 1)
+		hot, err := watchdir.NewHotFolder(watchdir.HotFolderConfig{
+			Dir: dir,
+			Processor: watchdir.ProcessorFunc(func(ctx context.Context, file watchdir.HotFile) error {
+				processed <- file.Name
+				return nil
+			}),
+			Interval: 10 * time.Millisecond,
+			Options:  []watchdir.Option{watchdir.WithWriteStabilityThreshold(0)},
+		})
+		require.NoError(t, err, "error creating hot folder")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			_ = hot.Run(ctx)
+		}()
+		select {
+		case name := <-processed:
+			require.Equal(t, "left.txt", name, "wrong file processed")
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the file to be processed")
+		}
+	})
+	t.Run("leaves files claimed by other instances", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, watchdir.DefaultHotFolderWorkDir, "other", "busy.txt"), "busy")
+		writeFile(t, filepath.Join(dir, "new.txt"), "new")
+
+		processed := make(chan string, 2)
+		hot, err := watchdir.NewHotFolder(watchdir.HotFolderConfig{
+			Dir: dir,
+			Processor: watchdir.ProcessorFunc(func(ctx context.Context, file watchdir.HotFile) error {
+				processed <- file.Name
+				return nil
+			}),
+			Instance: "mine",
+			Interval: 10 * time.Millisecond,
+			Options:  []watchdir.Option{watchdir.WithWriteStabilityThreshold(0)},
+		})
+		require.NoError(t, err, "error creating hot folder")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			_ = hot.Run(ctx)
+		}()
+		select {
+		case name := <-processed:
+			require.Equal(t, "new.txt", name, "wrong file processed")
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the file to be processed")
+		}
+		time.Sleep(50 * time.Millisecond)
+		require.Empty(t, processed, "the other instance's file should not be processed")
+		require.FileExists(t, filepath.Join(dir, watchdir.DefaultHotFolderWorkDir, "other", "busy.txt"), "the other instance's file should be left alone")
+	})
+	t.Run("keeps results for files with the same name", func(t *testing.T) {
+		dir := t.TempDir()
+		results := make(chan watchdir.HotFolderResult, 1)
+		hot, err := watchdir.NewHotFolder(watchdir.HotFolderConfig{
+			Dir: dir,
+			Processor: watchdir.ProcessorFunc(func(ctx context.Context, file watchdir.HotFile) error {
+				return nil
+			}),
+			Interval: 10 * time.Millisecond,
+			Options:  []watchdir.Option{watchdir.WithWriteStabilityThreshold(0)},
+			Report: func(result watchdir.HotFolderResult) {
+				results <- result
+			},
+		})
+		require.NoError(t, err, "error creating hot folder")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			_ = hot.Run(ctx)
+		}()
+
+		// Drop the same name twice, waiting for a sweep to see that the first was claimed before dropping the second
+		var dests []string
+		for _, content := range []string{"first", "second"} {
+			time.Sleep(50 * time.Millisecond)
+			writeFile(t, filepath.Join(dir, "report.csv"), content)
+			select {
+			case result := <-results:
+				dests = append(dests, result.Dest)
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the file to be processed")
+			}
+		}
+		require.Equal(t, []string{"done/report.csv", "done/report-1.csv"}, dests, "wrong destinations")
+		for dest, content := range map[string]string{"done/report.csv": "first", "done/report-1.csv": "second"} {
+			data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(dest)))
+			require.NoError(t, err, "error reading result")
+			require.Equal(t, content, string(data), "result should not be replaced")
+		}
+	})
+	t.Run("processes a file dropped with the same name before the next sweep", func(t *testing.T) {
+		dir := t.TempDir()
+		results := make(chan watchdir.HotFolderResult, 2)
+		var once sync.Once
+		hot, err := watchdir.NewHotFolder(watchdir.HotFolderConfig{
+			Dir: dir,
+			Processor: watchdir.ProcessorFunc(func(ctx context.Context, file watchdir.HotFile) error {
+				// Drop the next file as soon as the first is claimed, well before the next sweep
+				once.Do(func() {
+					writeFile(t, filepath.Join(dir, "report.csv"), "second")
+				})
+				return nil
+			}),
+			Interval: 200 * time.Millisecond,
+			Options:  []watchdir.Option{watchdir.WithWriteStabilityThreshold(0)},
+			Report: func(result watchdir.HotFolderResult) {
+				results <- result
+			},
+		})
+		require.NoError(t, err, "error creating hot folder")
+		writeFile(t, filepath.Join(dir, "report.csv"), "first")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			_ = hot.Run(ctx)
+		}()
+		var dests []string
+		for range 2 {
+			select {
+			case result := <-results:
+				dests = append(dests, result.Dest)
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the file to be processed")
+			}
+		}
+		require.Equal(t, []string{"done/report.csv", "done/report-1.csv"}, dests, "wrong destinations")
+	})
+	t.Run("keeps results for files with the same name finished at the same time", func(t *testing.T) {
+		const files = 8
+		dir := t.TempDir()
+		release := make(chan struct{})
+		results := make(chan watchdir.HotFolderResult, files)
+		hot, err := watchdir.NewHotFolder(watchdir.HotFolderConfig{
+			Dir: dir,
+			Processor: watchdir.ProcessorFunc(func(ctx context.Context, file watchdir.HotFile) error {
+				<-release
+				return nil
+			}),
+			Workers:  files,
+			Interval: 10 * time.Millisecond,
+			Options:  []watchdir.Option{watchdir.WithWriteStabilityThreshold(0)},
+			Report: func(result watchdir.HotFolderResult) {
+				results <- result
+			},
+		})
+		require.NoError(t, err, "error creating hot folder")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			_ = hot.Run(ctx)
+		}()
+
+		// Drop the same name repeatedly, waiting for each file to be claimed, then let every worker finish at once
+		name := filepath.Join(dir, "report.csv")
+		for i := range files {
+			writeFile(t, name, strconv.Itoa(i))
+			require.Eventually(t, func() bool {
+				_, err := os.Stat(name)
+				return errors.Is(err, os.ErrNotExist)
+			}, 5*time.Second, time.Millisecond, "file should be claimed")
+		}
+		close(release)
+
+		dests := make(map[string]bool)
+		for range files {
+			select {
+			case result := <-results:
+				dests[result.Dest] = true
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the files to be processed")
+			}
+		}
+		require.Len(t, dests, files, "every file should be moved to its own name")
+		contents := make(map[string]bool)
+		for _, file := range listFiles(t, filepath.Join(dir, watchdir.DefaultHotFolderDoneDir)) {
+			data, err := os.ReadFile(filepath.Join(dir, watchdir.DefaultHotFolderDoneDir, filepath.FromSlash(file)))
+			require.NoError(t, err, "error reading result")
+			contents[string(data)] = true
+		}
+		require.Len(t, contents, files, "no result should be replaced")
+	})
+}
@@ -0,0 +1,380 @@
+package watchdir
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultJournalAckTimeout is the default time a delivered event may go unacknowledged before it is redelivered.
+	DefaultJournalAckTimeout = time.Minute
+
+	// DefaultJournalCompactThreshold is the default number of acknowledgements written to the log before it is
+	// compacted.
+	DefaultJournalCompactThreshold = 1000
+
+	// journalFile is the name of the log file within the journal directory.
+	journalFile = "journal.log"
+)
+
+// JournalConfig configures a Journal.
+type JournalConfig struct {
+	// Dir is the directory that holds the log. It is created if it doesn't exist.
+	Dir string
+
+	// AckTimeout is the time a delivered event may go unacknowledged before it is delivered again. Defaults to
+	// DefaultJournalAckTimeout.
+	AckTimeout time.Duration
+
+	// CompactThreshold is the number of acknowledgements written to the log before it is rewritten to contain only the
+	// unacknowledged events. Defaults to DefaultJournalCompactThreshold.
+	CompactThreshold int
+}
+
+// JournalEvent is an event delivered by a journal, along with the ID used to acknowledge it.
+type JournalEvent struct {
+	ID uint64 `json:"id"`
+	Event
+}
+
+// journalRecord is a single line of the log, which either records an event or acknowledges one.
+type journalRecord struct {
+	Ack uint64 `json:"ack,omitempty"`
+	*JournalEvent
+}
+
+// journalEntry is an unacknowledged event, and when it is due to be delivered again. The due time is zero if it has
+// never been delivered.
+type journalEntry struct {
+	event JournalEvent
+	due   time.Time
+}
+
+// journalDeadline is the time a delivered event is due to be delivered again, unless it is acknowledged first.
+type journalDeadline struct {
+	id  uint64
+	due time.Time
+}
+
+// journalDeadlines is a min-heap of redelivery deadlines, ordered by due time and then by ID. Deadlines are left in the
+// heap when their event is acknowledged or delivered again, and skipped once they reach the top.
+type journalDeadlines []journalDeadline
+
+func (h journalDeadlines) Len() int { return len(h) }
+func (h journalDeadlines) Less(i, j int) bool {
+	if h[i].due.Equal(h[j].due) {
+		return h[i].id < h[j].id
+	}
+	return h[i].due.Before(h[j].due)
+}
+func (h journalDeadlines) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *journalDeadlines) Push(x any)   { *h = append(*h, x.(journalDeadline)) }
+func (h *journalDeadlines) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// Journal provides at-least-once delivery of events, backed by a write-ahead log on disk. Each event is written to the
+// log before it is delivered, and is delivered again if it isn't acknowledged within the timeout, or if the process
+// restarts before it is acknowledged. Consumers must therefore be prepared to handle an event more than once.
+type Journal struct {
+	cfg  JournalConfig
+	wake chan struct{}
+
+	mu      sync.Mutex
+	file    *os.File
+	nextID  uint64
+	order   []uint64 // Every event in the log, in the order they were written, which is kept for compaction
+	entries map[uint64]*journalEntry
+	acks    int
+
+	// undelivered holds the events that have never been delivered, oldest first, and deadlines holds the times that
+	// delivered events are due to be delivered again. Acknowledged events are skipped when they reach the front.
+	undelivered []uint64
+	deadlines   journalDeadlines
+}
+
+// OpenJournal opens the journal in a directory. Any events that were not acknowledged by a previous run are delivered
+// again once Run is called.
+func OpenJournal(cfg JournalConfig) (*Journal, error) {
+	if cfg.AckTimeout <= 0 {
+		cfg.AckTimeout = DefaultJournalAckTimeout
+	}
+	if cfg.CompactThreshold <= 0 {
+		cfg.CompactThreshold = DefaultJournalCompactThreshold
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create journal dir: %w", err)
+	}
+
+	j := &Journal{
+		cfg:     cfg,
+		wake:    make(chan struct{}, 1),
+		nextID:  1,
+		entries: make(map[uint64]*journalEntry),
+	}
+	if err := j.replay(); err != nil {
+		return nil, err
+	}
+
+	// Rewrite the log straight away, which also discards any record left incomplete by a crash
+	if err := j.compact(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// replay reads the log, and rebuilds the set of unacknowledged events.
+func (j *Journal) replay() error {
+	file, err := os.Open(j.filename())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open journal: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var record journalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// Only the last record can be incomplete, so there is nothing more to read
+			break
+		}
+		switch {
+		case record.JournalEvent != nil:
+			j.order = append(j.order, record.ID)
+			j.undelivered = append(j.undelivered, record.ID)
+			j.entries[record.ID] = &journalEntry{event: *record.JournalEvent}
+			j.nextID = max(j.nextID, record.ID+1)
+		case record.Ack != 0:
+			delete(j.entries, record.Ack)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read journal: %w", err)
+	}
+	return nil
+}
+
+// Run writes events received from the channel to the log, and delivers them to the output channel. It returns once the
+// input channel is closed and every event has been acknowledged, or when the context is cancelled.
+func (j *Journal) Run(ctx context.Context, chanEvents <-chan Event, chanOut chan<- JournalEvent) error {
+	timerRedeliver := time.NewTimer(0)
+	defer timerRedeliver.Stop()
+
+	for {
+		j.mu.Lock()
+		next, ready, wait := j.nextDelivery(time.Now())
+		done := chanEvents == nil && len(j.entries) == 0
+		j.mu.Unlock()
+		if done {
+			return nil
+		}
+
+		// Only offer an event to the consumer if one is due, and otherwise wait until the next one is
+		var chanSend chan<- JournalEvent
+		var chanRedeliver <-chan time.Time
+		if ready {
+			chanSend = chanOut
+		} else if wait > 0 {
+			timerRedeliver.Reset(wait)
+			chanRedeliver = timerRedeliver.C
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-chanEvents:
+			if !ok {
+				chanEvents = nil // Channel closed
+				continue
+			}
+			if err := j.append(event); err != nil {
+				return err
+			}
+		case chanSend <- next:
+			j.mu.Lock()
+			j.delivered(next.ID, time.Now())
+			j.mu.Unlock()
+		case <-chanRedeliver:
+		case <-j.wake:
+		}
+	}
+}
+
+// nextDelivery returns the oldest event whose acknowledgement has timed out, or otherwise the oldest event that has
+// never been delivered. If there is none, it returns the time until the next acknowledgement times out, or zero if no
+// events are waiting.
+func (j *Journal) nextDelivery(now time.Time) (JournalEvent, bool, time.Duration) {
+	var wait time.Duration
+	for len(j.deadlines) > 0 {
+		deadline := j.deadlines[0]
+		entry, ok := j.entries[deadline.id]
+		if !ok || !entry.due.Equal(deadline.due) {
+			heap.Pop(&j.deadlines) // Acknowledged, or delivered again since
+			continue
+		}
+		if wait = deadline.due.Sub(now); wait <= 0 {
+			return entry.event, true, 0
+		}
+		break
+	}
+	for len(j.undelivered) > 0 {
+		entry, ok := j.entries[j.undelivered[0]]
+		if !ok {
+			j.undelivered = j.undelivered[1:] // Acknowledged before it was delivered
+			continue
+		}
+		return entry.event, true, 0
+	}
+	return JournalEvent{}, false, wait
+}
+
+// delivered records that an event was delivered, and when it is due to be delivered again.
+func (j *Journal) delivered(id uint64, now time.Time) {
+	if len(j.undelivered) > 0 && j.undelivered[0] == id {
+		j.undelivered = j.undelivered[1:]
+	}
+	entry, ok := j.entries[id]
+	if !ok {
+		return // Acknowledged while it was being delivered
+	}
+	entry.due = now.Add(j.cfg.AckTimeout)
+	heap.Push(&j.deadlines, journalDeadline{id: id, due: entry.due})
+}
+
+// append assigns an ID to an event, and writes it to the log before it can be delivered.
+func (j *Journal) append(event Event) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	record := JournalEvent{ID: j.nextID, Event: event}
+	if err := j.write(journalRecord{JournalEvent: &record}); err != nil {
+		return fmt.Errorf("write event: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("sync journal: %w", err)
+	}
+	j.nextID++
+	j.order = append(j.order, record.ID)
+	j.undelivered = append(j.undelivered, record.ID)
+	j.entries[record.ID] = &journalEntry{event: record}
+	return nil
+}
+
+// Ack acknowledges an event, so that it is never delivered again. Acknowledging an event more than once, or an ID that
+// is unknown, has no effect.
+func (j *Journal) Ack(id uint64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, ok := j.entries[id]; !ok {
+		return nil
+	}
+	if err := j.write(journalRecord{Ack: id}); err != nil {
+		return fmt.Errorf("write ack: %w", err)
+	}
+	delete(j.entries, id)
+
+	// Compact the log once enough acknowledgements have built up
+	j.acks++
+	if j.acks >= j.cfg.CompactThreshold {
+		if err := j.compact(); err != nil {
+			return err
+		}
+	}
+
+	select {
+	case j.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Pending returns the number of events that haven't been acknowledged.
+func (j *Journal) Pending() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.entries)
+}
+
+// Close closes the log file. The journal must not be used after it is closed.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
+
+func (j *Journal) filename() string {
+	return filepath.Join(j.cfg.Dir, journalFile)
+}
+
+// write appends a record to the log.
+func (j *Journal) write(record journalRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = j.file.Write(append(data, '\n'))
+	return err
+}
+
+// compact rewrites the log to contain only the unacknowledged events, and reopens it for appending. The new log is
+// written to a temporary file first, so a crash can't lose any events.
+func (j *Journal) compact() error {
+	tmp := j.filename() + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("compact journal: %w", err)
+	}
+	writer := bufio.NewWriter(file)
+	live := make([]uint64, 0, len(j.entries))
+	for _, id := range j.order {
+		entry, ok := j.entries[id]
+		if !ok {
+			continue // Acknowledged
+		}
+		live = append(live, id)
+		data, err := json.Marshal(journalRecord{JournalEvent: &entry.event})
+		if err == nil {
+			_, err = writer.Write(append(data, '\n'))
+		}
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("compact journal: %w", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return fmt.Errorf("compact journal: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("compact journal: %w", err)
+	}
+	if err := os.Rename(tmp, j.filename()); err != nil {
+		file.Close()
+		return fmt.Errorf("compact journal: %w", err)
+	}
+
+	// The renamed file is now the log, so keep appending to it
+	if j.file != nil {
+		j.file.Close()
+	}
+	j.file = file
+	j.order = live
+	j.acks = 0
+	return nil
+}
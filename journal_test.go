@@ -0,0 +1,161 @@
+package watchdir_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spiretechnology/go-watchdir/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// receiveJournalEvent waits for the next event delivered by a journal.
+func receiveJournalEvent(t *testing.T, chanOut <-chan watchdir.JournalEvent) watchdir.JournalEvent {
+	select {
+	case event := <-chanOut:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+		return watchdir.JournalEvent{}
+	}
+}
+
+// runJournal runs a journal in the background, and returns a function that stops it.
+func runJournal(t *testing.T, journal *watchdir.Journal, chanEvents <-chan watchdir.Event, chanOut chan<- watchdir.JournalEvent) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	chanErr := make(chan error, 1)
+	go func() {
+		chanErr <- journal.Run(ctx, chanEvents, chanOut)
+	}()
+	return func() {
+		cancel()
+		require.ErrorIs(t, <-chanErr, context.Canceled)
+	}
+}
+
+func TestJournal(t *testing.T) {
+	t.Run("redelivers unacknowledged events after a timeout and a restart", func(t *testing.T) {
+		dir := t.TempDir()
+		journal, err := watchdir.OpenJournal(watchdir.JournalConfig{Dir: dir, AckTimeout: 20 * time.Millisecond})
+		require.NoError(t, err, "error opening journal")
+
+		chanEvents := make(chan watchdir.Event)
+		chanOut := make(chan watchdir.JournalEvent)
+		stop := runJournal(t, journal, chanEvents, chanOut)
+		for _, name := range []string{"a", "b", "c"} {
+			chanEvents <- watchdir.Event{Type: watchdir.FileAdded, File: name}
+			event := receiveJournalEvent(t, chanOut)
+			require.Equal(t, name, event.File, "wrong event delivered")
+			if name != "b" {
+				require.NoError(t, journal.Ack(event.ID), "error acknowledging event")
+			}
+		}
+
+		// The event that wasn't acknowledged is delivered again
+		event := receiveJournalEvent(t, chanOut)
+		require.Equal(t, watchdir.JournalEvent{ID: 2, Event: watchdir.Event{Type: watchdir.FileAdded, File: "b"}}, event, "wrong event redelivered")
+		stop()
+		require.NoError(t, journal.Close(), "error closing journal")
+
+		// After a restart, the event is delivered again, and new events continue the sequence
+		journal, err = watchdir.OpenJournal(watchdir.JournalConfig{Dir: dir})
+		require.NoError(t, err, "error reopening journal")
+		defer journal.Close()
+		require.Equal(t, 1, journal.Pending(), "wrong number of pending events")
+
+		chanEvents = make(chan watchdir.Event, 1)
+		chanEvents <- watchdir.Event{Type: watchdir.FileRemoved, File: "a"}
+		close(chanEvents)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		chanErr := make(chan error, 1)
+		go func() {
+			chanErr <- journal.Run(ctx, chanEvents, chanOut)
+		}()
+		event = receiveJournalEvent(t, chanOut)
+		require.Equal(t, uint64(2), event.ID, "wrong event delivered after restart")
+		require.NoError(t, journal.Ack(event.ID), "error acknowledging event")
+		event = receiveJournalEvent(t, chanOut)
+		require.Equal(t, watchdir.JournalEvent{ID: 4, Event: watchdir.Event{Type: watchdir.FileRemoved, File: "a"}}, event, "wrong new event")
+		require.NoError(t, journal.Ack(event.ID), "error acknowledging event")
+
+		// Once the input is closed and everything is acknowledged, Run returns
+		require.NoError(t, <-chanErr, "error running journal")
+	})
+	t.Run("compacts the log once events are acknowledged", func(t *testing.T) {
+		dir := t.TempDir()
+		journal, err := watchdir.OpenJournal(watchdir.JournalConfig{Dir: dir, CompactThreshold: 2})
+		require.NoError(t, err, "error opening journal")
+		defer journal.Close()
+
+		chanEvents := make(chan watchdir.Event)
+		chanOut := make(chan watchdir.JournalEvent)
+		stop := runJournal(t, journal, chanEvents, chanOut)
+		defer stop()
+		var ids []uint64
+		for _, name := range []string{"a", "b", "c"} {
+			chanEvents <- watchdir.Event{Type: watchdir.FileAdded, File: name}
+			ids = append(ids, receiveJournalEvent(t, chanOut).ID)
+		}
+		require.NoError(t, journal.Ack(ids[0]), "error acknowledging event")
+		require.NoError(t, journal.Ack(ids[2]), "error acknowledging event")
+
+		data, err := os.ReadFile(filepath.Join(dir, "journal.log"))
+		require.NoError(t, err, "error reading log")
+		require.Equal(t, `{"id":2,"type":"added","file":"b"}`, strings.TrimSpace(string(data)), "log should only hold pending events")
+	})
+	t.Run("delivers and redelivers a backlog in order", func(t *testing.T) {
+		journal, err := watchdir.OpenJournal(watchdir.JournalConfig{Dir: t.TempDir(), AckTimeout: 100 * time.Millisecond})
+		require.NoError(t, err, "error opening journal")
+		defer journal.Close()
+
+		const count = 200
+		chanEvents := make(chan watchdir.Event, count)
+		for i := range count {
+			chanEvents <- watchdir.Event{Type: watchdir.FileAdded, File: fmt.Sprint(i)}
+		}
+		close(chanEvents)
+		chanOut := make(chan watchdir.JournalEvent)
+		chanErr := make(chan error, 1)
+		go func() {
+			chanErr <- journal.Run(context.Background(), chanEvents, chanOut)
+		}()
+
+		// Nothing is acknowledged, so every event is delivered and then redelivered, each time in the order it was written
+		deliveries := make(map[uint64]int)
+		var first, second []uint64
+		for len(second) < count {
+			event := receiveJournalEvent(t, chanOut)
+			deliveries[event.ID]++
+			switch deliveries[event.ID] {
+			case 1:
+				first = append(first, event.ID)
+			case 2:
+				second = append(second, event.ID)
+			}
+		}
+		require.Len(t, first, count, "every event should be delivered")
+		require.True(t, slices.IsSorted(first), "events should be delivered in order")
+		require.True(t, slices.IsSorted(second), "events should be redelivered in order")
+
+		// Once everything is acknowledged, Run returns
+		for id := range deliveries {
+			require.NoError(t, journal.Ack(id), "error acknowledging event")
+		}
+		for {
+			select {
+			case <-chanOut:
+				continue // Redelivered before it was acknowledged
+			case err := <-chanErr:
+				require.NoError(t, err, "error running journal")
+			}
+			break
+		}
+		require.Zero(t, journal.Pending(), "no events should be pending")
+	})
+}
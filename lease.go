@@ -0,0 +1,344 @@
+package watchdir
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math/rand/v2"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultLeaseTTL is the default time a lease may go without being renewed before another instance takes it over.
+	DefaultLeaseTTL = 15 * time.Second
+)
+
+// LeaseConfig configures a Lease.
+type LeaseConfig struct {
+	// Path is the lease file, which must be on a file system shared by every instance. If it is inside the watched
+	// directory, it should be excluded with a file filter so that it isn't reported as an event.
+	Path string
+
+	// ID identifies this instance in the lease file, and must be unique among the instances. Defaults to the host name
+	// and process ID, along with a random suffix.
+	ID string
+
+	// TTL is the time the lease may go without being renewed before another instance takes it over. Defaults to
+	// DefaultLeaseTTL.
+	TTL time.Duration
+
+	// RenewInterval is the time between attempts to acquire or renew the lease, and must be less than half the TTL. The
+	// leader steps down once the lease hasn't been renewed for the TTL less this interval. Defaults to a third of the
+	// TTL.
+	RenewInterval time.Duration
+
+	// StatePath, if not empty, is a file on the same shared file system that the leader writes the files it knows about
+	// to each time it commits changes. An instance that takes over the lease restores its view of the directory from
+	// this file before its first sweep, so that it reports every change since the previous leader's last sweep.
+	// Without it, a new leader reports every file it didn't know about itself, which includes files the previous leader
+	// already reported. Like the lease file, it should be excluded from the sweep if it is inside the watched directory.
+	StatePath string
+
+	// Logger, if not nil, receives a line each time this instance gains or loses the lease.
+	Logger *log.Logger
+}
+
+// leaseRecord is the content of the lease file. The sequence number is incremented each time the lease is renewed.
+type leaseRecord struct {
+	Holder string `json:"holder"`
+	Seq    uint64 `json:"seq"`
+}
+
+// Lease elects a single leader among watcher instances that share a lease file. The leader renews the lease
+// periodically, and the other instances take it over once it hasn't changed for the TTL. Expiry is judged by each
+// instance's own clock, so the instances' clocks don't need to agree.
+//
+// Pass a lease to WithLease so that only the leader reports events.
+type Lease struct {
+	cfg LeaseConfig
+
+	mu          sync.Mutex
+	leader      bool
+	seq         uint64
+	lastRenewed time.Time
+	observed    []byte
+	observedAt  time.Time
+}
+
+// NewLease creates a lease. The lease is contended for once Run is called.
+func NewLease(cfg LeaseConfig) (*Lease, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("lease path is required")
+	}
+	if cfg.ID == "" {
+		hostname, _ := os.Hostname()
+		cfg.ID = fmt.Sprintf("%s-%d-%08x", hostname, os.Getpid(), rand.Uint32())
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultLeaseTTL
+	}
+	if cfg.RenewInterval <= 0 {
+		cfg.RenewInterval = cfg.TTL / 3
+	}
+	if cfg.RenewInterval >= cfg.TTL/2 {
+		return nil, errors.New("lease renew interval must be less than half the TTL")
+	}
+	return &Lease{cfg: cfg}, nil
+}
+
+// IsLeader returns true while this instance holds the lease.
+func (l *Lease) IsLeader() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.leader
+}
+
+// Run acquires and renews the lease until the context is cancelled. If this instance holds the lease when Run returns,
+// the lease file is removed so another instance can take over straight away.
+func (l *Lease) Run(ctx context.Context) error {
+	ticker := time.NewTicker(l.cfg.RenewInterval)
+	defer ticker.Stop()
+
+	for {
+		l.tick(time.Now())
+		select {
+		case <-ctx.Done():
+			l.release()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// tick reads the lease file, then renews the lease if this instance holds it, or takes it over if it has expired.
+func (l *Lease) tick(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	data, err := os.ReadFile(l.cfg.Path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		l.acquire(now)
+	case err != nil:
+		l.logf("read lease: %v", err)
+	default:
+		var record leaseRecord
+		if json.Unmarshal(data, &record) == nil && record.Holder == l.cfg.ID {
+			l.seq = record.Seq
+			l.renew(now)
+			break
+		}
+
+		// Another instance holds the lease, so wait for it to stop changing
+		l.setLeader(false)
+		if !bytes.Equal(data, l.observed) {
+			l.observed, l.observedAt = data, now
+		} else if now.Sub(l.observedAt) >= l.cfg.TTL {
+			l.takeOver(now, data)
+		}
+	}
+
+	// Step down if the lease couldn't be renewed in time. Other instances may take it over as soon as the TTL has
+	// passed by their own clocks, so step down a renew interval early to leave room for clock drift and slow renewals.
+	if margin := l.cfg.TTL - l.cfg.RenewInterval; l.leader && now.Sub(l.lastRenewed) >= margin {
+		l.logf("lease not renewed within %s", margin)
+		l.setLeader(false)
+	}
+}
+
+// acquire creates the lease file, which only succeeds for one instance if several try at once.
+func (l *Lease) acquire(now time.Time) {
+	file, err := os.OpenFile(l.cfg.Path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		if !errors.Is(err, fs.ErrExist) {
+			l.logf("create lease: %v", err)
+		}
+		return
+	}
+	l.seq = 1
+	data, err := json.Marshal(leaseRecord{Holder: l.cfg.ID, Seq: l.seq})
+	if err == nil {
+		_, err = file.Write(data)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		l.logf("write lease: %v", err)
+		os.Remove(l.cfg.Path)
+		return
+	}
+	l.lastRenewed = now
+	l.setLeader(true)
+}
+
+// renew writes the lease file with the next sequence number, so that the other instances see it is still held.
+func (l *Lease) renew(now time.Time) {
+	data, err := json.Marshal(leaseRecord{Holder: l.cfg.ID, Seq: l.seq + 1})
+	if err != nil {
+		l.logf("renew lease: %v", err)
+		return
+	}
+	tmp := l.cfg.Path + "." + l.cfg.ID + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		l.logf("renew lease: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, l.cfg.Path); err != nil {
+		l.logf("renew lease: %v", err)
+		return
+	}
+	l.seq++
+	l.lastRenewed = now
+	l.setLeader(true)
+}
+
+// takeOver replaces an expired lease. The expired file is first renamed aside, which only succeeds for one instance,
+// and is checked to make sure it wasn't renewed in the meantime.
+func (l *Lease) takeOver(now time.Time, expired []byte) {
+	stale := l.cfg.Path + "." + l.cfg.ID + ".stale"
+	if err := os.Rename(l.cfg.Path, stale); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			l.logf("take over lease: %v", err)
+		}
+		return
+	}
+	defer os.Remove(stale)
+
+	data, err := os.ReadFile(stale)
+	if err != nil || !bytes.Equal(data, expired) {
+		// The lease was renewed or taken over just before it was renamed, so put it back unless it has been replaced
+		if err := os.Link(stale, l.cfg.Path); err != nil && !errors.Is(err, fs.ErrExist) {
+			l.logf("restore lease: %v", err)
+		}
+		return
+	}
+	l.observed = nil
+	l.acquire(now)
+}
+
+// release removes the lease file if this instance holds it.
+func (l *Lease) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.leader {
+		return
+	}
+	l.setLeader(false)
+
+	data, err := os.ReadFile(l.cfg.Path)
+	if err != nil {
+		return
+	}
+	var record leaseRecord
+	if json.Unmarshal(data, &record) == nil && record.Holder == l.cfg.ID {
+		if err := os.Remove(l.cfg.Path); err != nil {
+			l.logf("release lease: %v", err)
+		}
+	}
+}
+
+// setLeader records whether this instance holds the lease, and logs the change. It must be called while holding the
+// lock.
+func (l *Lease) setLeader(leader bool) {
+	if leader == l.leader {
+		return
+	}
+	l.leader = leader
+	if leader {
+		l.logf("acquired lease %s as %s", l.cfg.Path, l.cfg.ID)
+	} else {
+		l.logf("lost lease %s", l.cfg.Path)
+	}
+}
+
+func (l *Lease) logf(format string, args ...any) {
+	if l.cfg.Logger != nil {
+		l.cfg.Logger.Printf(format, args...)
+	}
+}
+
+// standby returns true if another instance holds the watcher's lease, in which case the watcher must not sweep. When
+// this instance has just become the leader, the watcher first takes over the state saved by the previous leader. It
+// must be called while holding the sweep lock.
+func (wd *watcher) standby() (bool, error) {
+	if wd.lease == nil {
+		return false, nil
+	}
+	if !wd.lease.IsLeader() {
+		wd.leading = false
+		return true, nil
+	}
+	if !wd.leading {
+		if err := wd.restoreLeaseState(); err != nil {
+			return false, err
+		}
+		wd.leading = true
+	}
+	return false, nil
+}
+
+// restoreLeaseState replaces the watcher's view of the directory with the files in the lease's state file. If there is
+// no state file, the watcher keeps its own view. It must be called while holding the sweep lock.
+func (wd *watcher) restoreLeaseState() error {
+	if wd.lease.cfg.StatePath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(wd.lease.cfg.StatePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read lease state: %w", err)
+	}
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("decode lease state: %w", err)
+	}
+
+	// Start from an empty cache, since anything this instance knew from an earlier term as leader may be out of date
+	wd.cache.mu.Lock()
+	wd.cache.entries = make(map[string]fs.DirEntry)
+	wd.cache.children = make(map[string]*dirCache)
+	wd.cache.pending = nil
+	wd.cache.mu.Unlock()
+	wd.restore(snap.entries)
+	wd.baselined = true
+	wd.generation++
+	return nil
+}
+
+// saveLeaseState writes the files known to the watcher to the lease's state file while this instance is the leader.
+// Failures are logged rather than returned, since the changes have already been committed. It must be called while
+// holding the sweep lock.
+func (wd *watcher) saveLeaseState() {
+	if wd.lease == nil || wd.lease.cfg.StatePath == "" || !wd.leading || !wd.lease.IsLeader() {
+		return
+	}
+	snap, err := wd.Snapshot(context.Background())
+	if err != nil {
+		wd.logger.Printf("save lease state: %v", err)
+		return
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		wd.logger.Printf("save lease state: %v", err)
+		return
+	}
+
+	// Write the state to a temporary file first, so the next leader never reads a partial state
+	tmp := wd.lease.cfg.StatePath + "." + wd.lease.cfg.ID + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		wd.logger.Printf("save lease state: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, wd.lease.cfg.StatePath); err != nil {
+		wd.logger.Printf("save lease state: %v", err)
+	}
+}
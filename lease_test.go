@@ -0,0 +1,151 @@
+package watchdir_test
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spiretechnology/go-watchdir/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// countingFS counts the files and directories opened in a file system.
+type countingFS struct {
+	fs.FS
+	opens atomic.Int64
+}
+
+func (f *countingFS) Open(name string) (fs.File, error) {
+	f.opens.Add(1)
+	return f.FS.Open(name)
+}
+
+// runLease runs a lease in the background, and returns a function that stops it.
+func runLease(t *testing.T, lease *watchdir.Lease) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	chanErr := make(chan error, 1)
+	go func() {
+		chanErr <- lease.Run(ctx)
+	}()
+	stopped := false
+	stop := func() {
+		if !stopped {
+			stopped = true
+			cancel()
+			require.ErrorIs(t, <-chanErr, context.Canceled, "lease should stop when cancelled")
+		}
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+func TestLease(t *testing.T) {
+	t.Run("only the leader sweeps, and a new leader reports what the previous one missed", func(t *testing.T) {
+		dir := t.TempDir()
+		shared := t.TempDir()
+		leasePath := filepath.Join(shared, "lease")
+		writeFile(t, filepath.Join(dir, "foo"), "")
+
+		// Start with a lease left behind by an instance that crashed
+		writeFile(t, leasePath, `{"holder":"crashed","seq":7}`)
+		newWatcher := func(id string) (*watchdir.Lease, *countingFS, watchdir.Watcher) {
+			lease, err := watchdir.NewLease(watchdir.LeaseConfig{
+				Path:          leasePath,
+				ID:            id,
+				TTL:           200 * time.Millisecond,
+				RenewInterval: 20 * time.Millisecond,
+				StatePath:     filepath.Join(shared, "state.json"),
+			})
+			require.NoError(t, err, "error creating lease")
+			fsys := &countingFS{FS: os.DirFS(dir)}
+			return lease, fsys, watchdir.New(fsys, watchdir.WithWriteStabilityThreshold(0), watchdir.WithLease(lease))
+		}
+		leaseA, _, wdA := newWatcher("a")
+		leaseB, fsysB, wdB := newWatcher("b")
+
+		// The first instance takes over the expired lease, and the second stands by while it is renewed
+		stopA := runLease(t, leaseA)
+		require.Eventually(t, leaseA.IsLeader, 5*time.Second, 10*time.Millisecond, "first instance should take over the lease")
+		runLease(t, leaseB)
+		require.Never(t, leaseB.IsLeader, 500*time.Millisecond, 10*time.Millisecond, "second instance should not take a renewed lease")
+
+		events, err := sweepAndCollectEvents(t, wdA)
+		require.NoError(t, err, "error sweeping")
+		require.ElementsMatch(t, []string{"foo"}, events[watchdir.FileAdded], "leader should report files")
+
+		// A file arrives after the leader's last sweep, and the follower doesn't sweep it
+		writeFile(t, filepath.Join(dir, "bar"), "")
+		events, err = sweepAndCollectEvents(t, wdB)
+		require.NoError(t, err, "error sweeping")
+		require.Empty(t, events, "follower should not report files")
+		require.Zero(t, fsysB.opens.Load(), "follower should not read the directory")
+
+		// Once the leader stops, the second instance takes over from the leader's state, so it reports the file the
+		// previous leader missed, but not the one it already reported
+		stopA()
+		require.Eventually(t, leaseB.IsLeader, 5*time.Second, 10*time.Millisecond, "second instance should take over the lease")
+		events, err = sweepAndCollectEvents(t, wdB)
+		require.NoError(t, err, "error sweeping")
+		require.Equal(t, map[watchdir.EventType][]string{watchdir.FileAdded: {"bar"}}, events, "new leader should report the missed file")
+	})
+	t.Run("without a state file, a new leader reports every file", func(t *testing.T) {
+		dir := t.TempDir()
+		leasePath := filepath.Join(t.TempDir(), "lease")
+		writeFile(t, filepath.Join(dir, "foo"), "")
+		writeFile(t, leasePath, `{"holder":"other","seq":1}`)
+		lease, err := watchdir.NewLease(watchdir.LeaseConfig{
+			Path:          leasePath,
+			ID:            "a",
+			TTL:           200 * time.Millisecond,
+			RenewInterval: 20 * time.Millisecond,
+		})
+		require.NoError(t, err, "error creating lease")
+		wd := watchdir.New(os.DirFS(dir), watchdir.WithWriteStabilityThreshold(0), watchdir.WithLease(lease))
+
+		events, err := sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.Empty(t, events, "follower should not report files")
+
+		runLease(t, lease)
+		require.Eventually(t, lease.IsLeader, 5*time.Second, 10*time.Millisecond, "instance should take over the expired lease")
+		events, err = sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.Equal(t, map[watchdir.EventType][]string{watchdir.FileAdded: {"foo"}}, events, "new leader should report every file")
+	})
+	t.Run("steps down before the lease expires", func(t *testing.T) {
+		leasePath := filepath.Join(t.TempDir(), "lease")
+		lease, err := watchdir.NewLease(watchdir.LeaseConfig{
+			Path:          leasePath,
+			ID:            "a",
+			TTL:           600 * time.Millisecond,
+			RenewInterval: 200 * time.Millisecond,
+		})
+		require.NoError(t, err, "error creating lease")
+		runLease(t, lease)
+		require.Eventually(t, lease.IsLeader, 5*time.Second, 10*time.Millisecond, "instance should acquire the lease")
+
+		// Block renewals by putting a directory where the renewed lease is written
+		require.NoError(t, os.Mkdir(leasePath+".a.tmp", 0o755), "error blocking renewals")
+		info, err := os.Stat(leasePath)
+		require.NoError(t, err, "error reading lease")
+		renewed := info.ModTime()
+
+		// A single failed renewal is retried, but the leader steps down a renew interval before the TTL has passed
+		require.Never(t, func() bool { return !lease.IsLeader() }, 150*time.Millisecond, 10*time.Millisecond, "leader should retry a failed renewal")
+		require.Eventually(t, func() bool { return !lease.IsLeader() }, 5*time.Second, 5*time.Millisecond, "leader should step down")
+		require.Less(t, time.Since(renewed), 500*time.Millisecond, "leader should step down well before the lease expires")
+	})
+
+	t.Run("rejects a renew interval too close to the TTL", func(t *testing.T) {
+		_, err := watchdir.NewLease(watchdir.LeaseConfig{
+			Path:          filepath.Join(t.TempDir(), "lease"),
+			TTL:           time.Second,
+			RenewInterval: 500 * time.Millisecond,
+		})
+		require.Error(t, err, "renew interval should leave room to step down")
+	})
+}
@@ -0,0 +1,60 @@
+package watchdir
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// MarkerConfig configures completion markers, which are files that signal when other files are ready to be reported.
+// A file with a sidecar marker or a directory marker is held back until either one appears.
+type MarkerConfig struct {
+	// Suffix, if not empty, is appended to a file's name to form the name of its sidecar marker, e.g. ".done" for
+	// "data.csv.done".
+	Suffix string
+
+	// DirMarker, if not empty, is the name of a marker that makes every file in the same directory and its
+	// subdirectories ready, e.g. "_SUCCESS". A marker at the top of a partitioned output, such as "job/_SUCCESS", makes
+	// "job/date=2024-01-01/part-0" ready. Ancestors are only searched up to the root of the sweep.
+	DirMarker string
+
+	// Hide prevents events from being sent for the markers themselves.
+	Hide bool
+
+	// Timeout, if positive, is the time a file can wait for its marker before a FileTimeout event is sent for it.
+	Timeout time.Duration
+}
+
+// isMarker returns true if a file is a completion marker.
+func (c *MarkerConfig) isMarker(name string) bool {
+	return (c.Suffix != "" && strings.HasSuffix(name, c.Suffix)) || (c.DirMarker != "" && name == c.DirMarker)
+}
+
+// hides returns true if a file is a marker that shouldn't be reported.
+func (c *MarkerConfig) hides(name string) bool {
+	return c.Hide && c.isMarker(name)
+}
+
+// ready returns true if a file can be reported, given the other files in its directory. The markedAbove function is
+// only called if the file isn't ready otherwise, since it looks for the directory marker in every ancestor.
+func (c *MarkerConfig) ready(name string, listing map[string]fs.DirEntry, markedAbove func() bool) bool {
+	if c.isMarker(name) || (c.Suffix == "" && c.DirMarker == "") {
+		return true
+	}
+	if c.Suffix != "" && listing[name+c.Suffix] != nil {
+		return true
+	}
+	return c.DirMarker != "" && (listing[c.DirMarker] != nil || markedAbove())
+}
+
+// markedAbove returns true if the directory marker exists in any ancestor of a directory within the file system.
+func (c *MarkerConfig) markedAbove(fsys fs.FS, dir string) bool {
+	for dir != "." {
+		dir = path.Dir(dir)
+		if _, err := fs.Stat(fsys, path.Join(dir, c.DirMarker)); err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package watchdir_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/spiretechnology/go-memfs"
+	"github.com/spiretechnology/go-watchdir/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarkers(t *testing.T) {
+	t.Run("reports files once their markers appear", func(t *testing.T) {
+		fsys := memfs.FS{
+			"data.csv":        memfs.File("1,2,3"),
+			"batch/part-0":    memfs.File("a"),
+			"batch/part-1":    memfs.File("b"),
+			"other/loose.txt": memfs.File("c"),
+		}
+		wd := watchdir.New(fsys, watchdir.WithWriteStabilityThreshold(0), watchdir.WithMarkers(watchdir.MarkerConfig{
+			Suffix:    ".done",
+			DirMarker: "_SUCCESS",
+			Hide:      true,
+		}))
+
+		// Nothing is reported until a marker appears
+		events, err := sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.Empty(t, events, "should not report files without markers")
+
+		// A sidecar marker releases its file, and a directory marker releases the whole directory
+		fsys["data.csv.done"] = memfs.File("")
+		fsys["batch/_SUCCESS"] = memfs.File("")
+		events, err = sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.ElementsMatch(t, []string{"data.csv", "batch/part-0", "batch/part-1"}, events[watchdir.FileAdded], "wrong files added")
+		require.Len(t, events, 1, "should only report added files")
+
+		// Hidden markers aren't reported when they are removed either
+		delete(fsys, "data.csv.done")
+		delete(fsys, "batch/_SUCCESS")
+		events, err = sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.Empty(t, events, "should not report hidden markers")
+	})
+	t.Run("directory markers release files in subdirectories", func(t *testing.T) {
+		fsys := memfs.FS{
+			"job/date=2024-01-01/part-0": memfs.File("a"),
+			"job/date=2024-01-02/part-0": memfs.File("b"),
+			"job/sub/b":                  memfs.File("c"),
+			"other/x/loose.txt":          memfs.File("d"),
+		}
+		wd := watchdir.New(fsys, watchdir.WithWriteStabilityThreshold(0), watchdir.WithMarkers(watchdir.MarkerConfig{
+			DirMarker: "_SUCCESS",
+			Hide:      true,
+		}))
+		events, err := sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.Empty(t, events, "should not report files without markers")
+
+		// A marker at the top of the output releases every file below it
+		fsys["job/_SUCCESS"] = memfs.File("")
+		events, err = sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.ElementsMatch(t, []string{"job/date=2024-01-01/part-0", "job/date=2024-01-02/part-0", "job/sub/b"}, events[watchdir.FileAdded], "wrong files added")
+		require.Len(t, events, 1, "should only report added files")
+	})
+	t.Run("reports a timeout for files without markers", func(t *testing.T) {
+		fsys := memfs.FS{
+			"data.csv": memfs.File("1,2,3"),
+		}
+		wd := watchdir.New(fsys, watchdir.WithWriteStabilityThreshold(0), watchdir.WithMarkers(watchdir.MarkerConfig{
+			Suffix:  ".done",
+			Timeout: 50 * time.Millisecond,
+		}))
+		events, err := sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.Empty(t, events, "should not report files without markers")
+
+		// The timeout is reported once
+		time.Sleep(100 * time.Millisecond)
+		events, err = sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.Equal(t, map[watchdir.EventType][]string{watchdir.FileTimeout: {"data.csv"}}, events, "should report a timeout")
+		events, err = sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.Empty(t, events, "should only report a timeout once")
+
+		// The file is still reported if its marker shows up late, along with the visible marker
+		fsys["data.csv.done"] = memfs.File("")
+		events, err = sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.ElementsMatch(t, []string{"data.csv", "data.csv.done"}, events[watchdir.FileAdded], "wrong files added")
+	})
+	t.Run("carries timeouts over partial commits", func(t *testing.T) {
+		fsys := memfs.FS{
+			"a.csv":      memfs.File("1,2,3"),
+			"sub/b.csv":  memfs.File("4,5,6"),
+			"c.csv":      memfs.File("7,8,9"),
+			"c.csv.done": memfs.File(""),
+		}
+		wd := watchdir.NewDirWatcher(fsys, watchdir.WithWriteStabilityThreshold(0), watchdir.WithMarkers(watchdir.MarkerConfig{
+			Suffix:  ".done",
+			Timeout: 50 * time.Millisecond,
+		}))
+		planFiles := func() (*watchdir.ChangeSet, []string) {
+			cs, err := wd.Plan(context.Background())
+			require.NoError(t, err, "error planning")
+			var files []string
+			for _, event := range cs.Events() {
+				files = append(files, event.Type.String()+" "+event.File)
+			}
+			return cs, files
+		}
+
+		// Files keep waiting for their markers while other changes are committed
+		cs, files := planFiles()
+		require.Equal(t, []string{"added c.csv", "added c.csv.done"}, files, "wrong changes planned")
+		require.NoError(t, cs.CommitPaths("c.csv", "c.csv.done"), "error committing paths")
+		time.Sleep(100 * time.Millisecond)
+		cs, files = planFiles()
+		require.Equal(t, []string{"timeout a.csv", "timeout sub/b.csv"}, files, "should time out files held back across commits")
+
+		// Committed timeouts aren't reported again, but the others are
+		require.NoError(t, cs.CommitPaths("a.csv"), "error committing paths")
+		cs, files = planFiles()
+		require.Equal(t, []string{"timeout sub/b.csv"}, files, "should report uncommitted timeouts again")
+		require.NoError(t, cs.Commit(), "error committing")
+		events, err := sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.Empty(t, events, "should only report a timeout once")
+	})
+}
@@ -0,0 +1,57 @@
+package watchdir
+
+import (
+	"log"
+	"path/filepath"
+	"sync"
+)
+
+// openFileCheck holds back files that a process still has open for writing. The open files are listed once per sweep,
+// the first time a file that would otherwise be reported is checked. If they can't be listed, such as on systems
+// without /proc, no files are held back and the write stability threshold applies on its own.
+type openFileCheck struct {
+	dir    string
+	logger *log.Logger
+
+	mu      sync.Mutex
+	sweepID uint64
+	open    map[string]bool
+	failed  bool
+}
+
+func newOpenFileCheck(dir string) *openFileCheck {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	return &openFileCheck{dir: dir}
+}
+
+// isOpen returns true if a file, given by its path relative to the watched directory, is open for writing.
+func (c *openFileCheck) isOpen(sweepID uint64, name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.open == nil || c.sweepID != sweepID {
+		open, err := listOpenFiles(c.dir)
+		if err != nil {
+			if !c.failed {
+				c.logger.Printf("open file check unavailable, relying on the write stability threshold: %v", err)
+			}
+			c.failed = true
+			open = map[string]bool{}
+		} else if c.failed {
+			c.logger.Println("open file check available again")
+			c.failed = false
+		}
+		c.sweepID, c.open = sweepID, open
+	}
+	return c.open[filepath.Join(c.dir, filepath.FromSlash(name))]
+}
+
+// isOpen returns true if the open file check is enabled and a file, given by its path relative to the sweep fs, is open
+// for writing.
+func (wd *watcher) isOpen(rel string) bool {
+	return wd.openFiles != nil && wd.openFiles.isOpen(wd.sweepID, wd.prependSubRoot(rel))
+}
@@ -0,0 +1,63 @@
+//go:build linux
+
+package watchdir
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// listOpenFiles returns the files within a directory that any process has open for writing, found by reading the file
+// descriptors of every process in /proc. Processes whose descriptors can't be read, such as those owned by other users
+// when not running as root, are skipped.
+func listOpenFiles(dir string) (map[string]bool, error) {
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	prefix := dir + string(filepath.Separator)
+	open := make(map[string]bool)
+	for _, proc := range procs {
+		if _, err := strconv.Atoi(proc.Name()); err != nil {
+			continue
+		}
+		fdDir := filepath.Join("/proc", proc.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(target, prefix) || open[target] {
+				continue
+			}
+			if openForWriting(filepath.Join("/proc", proc.Name(), "fdinfo", fd.Name())) {
+				open[target] = true
+			}
+		}
+	}
+	return open, nil
+}
+
+// openForWriting returns true if the flags in a file descriptor's fdinfo show that it was opened for writing.
+func openForWriting(fdinfo string) bool {
+	data, err := os.ReadFile(fdinfo)
+	if err != nil {
+		return false
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "flags:")
+		if !ok {
+			continue
+		}
+		flags, err := strconv.ParseUint(strings.TrimSpace(value), 8, 64)
+		return err == nil && flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+//go:build linux
+
+package watchdir_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spiretechnology/go-watchdir/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenFileCheck(t *testing.T) {
+	t.Run("holds back files open for writing", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, "done.txt"), "done")
+		file, err := os.Create(filepath.Join(dir, "writing.txt"))
+		require.NoError(t, err, "error creating file")
+		defer file.Close()
+
+		// Files that are only open for reading aren't held back
+		reader, err := os.Open(filepath.Join(dir, "done.txt"))
+		require.NoError(t, err, "error opening file")
+		defer reader.Close()
+
+		wd := watchdir.New(os.DirFS(dir), watchdir.WithWriteStabilityThreshold(0), watchdir.WithOpenFileCheck(dir))
+		events, err := sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.Equal(t, map[watchdir.EventType][]string{watchdir.FileAdded: {"done.txt"}}, events, "should hold back open files")
+
+		// The file is reported once it is closed
+		require.NoError(t, file.Close(), "error closing file")
+		events, err = sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.Equal(t, map[watchdir.EventType][]string{watchdir.FileAdded: {"writing.txt"}}, events, "should report closed files")
+	})
+	t.Run("holds back bundles with a member open for writing", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, "scan.xml"), "metadata")
+		file, err := os.Create(filepath.Join(dir, "scan.tif"))
+		require.NoError(t, err, "error creating file")
+		defer file.Close()
+
+		wd := watchdir.New(os.DirFS(dir),
+			watchdir.WithWriteStabilityThreshold(0),
+			watchdir.WithOpenFileCheck(dir),
+			watchdir.WithBundles(watchdir.BundleRule{Extensions: []string{".tif", ".xml"}}),
+		)
+		events, err := sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.Empty(t, events, "should hold back the bundle while a member is open")
+
+		// The bundle is reported once the member is closed
+		require.NoError(t, file.Close(), "error closing file")
+		events, err = sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.Equal(t, map[watchdir.EventType][]string{watchdir.BundleReady: {"scan"}}, events, "should report the bundle")
+	})
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package watchdir
+
+import "errors"
+
+// listOpenFiles isn't supported outside of Linux, so no files are held back.
+func listOpenFiles(dir string) (map[string]bool, error) {
+	return nil, errors.New("open files can only be listed on linux")
+}
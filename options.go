@@ -26,6 +26,15 @@ func WithWriteStabilityThreshold(threshold time.Duration) Option {
 	}
 }
 
+// WithStableObservations considers a file stable once its size and modification time are unchanged for the given number
+// of consecutive sweeps, instead of comparing its modification time to the clock with the write stability threshold.
+// This suits file servers whose clocks are skewed from the watcher's. A value of 0 restores the threshold.
+func WithStableObservations(n int) Option {
+	return func(wd *watcher) {
+		wd.stableObservations = n
+	}
+}
+
 func WithFileFilter(filter Filter) Option {
 	return func(wd *watcher) {
 		wd.fileFilter = filter
@@ -56,6 +65,79 @@ func WithSubRoot(root string) Option {
 	}
 }
 
+// WithSilentBaseline causes the initial sweep to record the files that already exist without sending events for them,
+// so that only files added or removed after the watcher starts are reported. Existing files are recorded whether or not
+// they are stable yet.
+func WithSilentBaseline() Option {
+	return func(wd *watcher) {
+		wd.silentBaseline = true
+	}
+}
+
+// WithSilentNewSubtrees causes the contents of directories that appear after the initial sweep to be recorded without
+// sending events, so that only changes within those directories after they are first seen are reported.
+func WithSilentNewSubtrees() Option {
+	return func(wd *watcher) {
+		wd.silentNewSubtrees = true
+	}
+}
+
+// WithSnapshot initializes the watcher with the files in a snapshot, as if it had already swept them. The first sweep
+// then reports only the changes since the snapshot was taken. Paths outside of the sub-root are ignored.
+func WithSnapshot(snap Snapshot) Option {
+	return func(wd *watcher) {
+		wd.initialSnapshot = &snap
+	}
+}
+
+// WithLease coordinates the watcher with other instances through a lease, which must be running. While another instance
+// holds the lease, sweeps, plans and rescans do nothing. When this instance takes over, its view of the directory is
+// restored from the lease's state file before its first sweep, so that it reports the changes since the previous
+// leader's last sweep.
+func WithLease(lease *Lease) Option {
+	return func(wd *watcher) {
+		wd.lease = lease
+	}
+}
+
+// WithPartition limits the watcher to the directories owned by this instance in a partition, which must not be shared
+// with any other watcher.
+func WithPartition(partition *Partition) Option {
+	return func(wd *watcher) {
+		wd.partition = partition
+	}
+}
+
+// WithMarkers holds back files until their completion markers appear, instead of reporting them as soon as they are
+// found.
+func WithMarkers(cfg MarkerConfig) Option {
+	return func(wd *watcher) {
+		wd.markers = &cfg
+	}
+}
+
+// WithBundles groups files into bundles that are reported together with a single BundleReady event, once every member
+// of a bundle has been found.
+func WithBundles(rules ...BundleRule) Option {
+	return func(wd *watcher) {
+		wd.bundles = append(wd.bundles, rules...)
+	}
+}
+
+// WithOpenFileCheck holds back new files while any process still has them open for writing, which is checked on Linux
+// by reading /proc. The directory is the path on the local file system that the watched file system reads from, such
+// as the directory passed to os.DirFS. Elsewhere, or if /proc can't be read, files are not held back by this check.
+//
+// The check reads the file descriptors of every process on the host, so its cost grows with the number of processes
+// and open files rather than with the watched directory. It runs at most once per sweep, and only in sweeps that find a
+// file that passes every other check and would otherwise be reported, but while such files are held back, each sweep
+// pays that cost again.
+func WithOpenFileCheck(dir string) Option {
+	return func(wd *watcher) {
+		wd.openFiles = newOpenFileCheck(dir)
+	}
+}
+
 func WithLogger(logger *log.Logger) Option {
 	return func(wd *watcher) {
 		wd.logger = logger
@@ -0,0 +1,458 @@
+package watchdir
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPartitionReplicas is the default number of points each member has on the hash ring.
+	DefaultPartitionReplicas = 64
+
+	// DefaultHandoverTimeout is the default time to wait for the previous owners of a partition to hand it over, after
+	// which the partition is swept from scratch.
+	DefaultHandoverTimeout = time.Minute
+)
+
+// PartitionConfig configures a Partition.
+type PartitionConfig struct {
+	// Self is the name of this instance, which must be one of the members for it to sweep anything.
+	Self string
+
+	// Members are the names of every cooperating instance. Each instance must be given the same members.
+	Members []string
+
+	// Level is the depth of the directories that are distributed between the members, below the sub-root. Files above
+	// this depth belong to a single member. Defaults to 1, which distributes the top-level directories.
+	Level int
+
+	// Replicas is the number of points each member has on the hash ring. More points spread the directories more evenly.
+	// Defaults to DefaultPartitionReplicas.
+	Replicas int
+
+	// HandoverDir is a directory shared by every instance, used to pass the files known to a directory's previous owner
+	// to its new owner when the members change. Without it, the new owner reports every file in the directories it
+	// takes over as added.
+	HandoverDir string
+
+	// HandoverTimeout is the time to wait for the previous owners to hand over their directories. Directories that
+	// haven't been handed over by then are swept from scratch. Defaults to DefaultHandoverTimeout.
+	HandoverTimeout time.Duration
+}
+
+// Partition divides a directory tree between cooperating watcher instances, using a consistent hash of the directories
+// at the configured level, so that each instance only sweeps its own share. When the members change, only the
+// directories whose owner changed are moved, and their previous owners hand them over so that no events are duplicated
+// or lost.
+//
+// Pass a partition to WithPartition. Each watcher needs its own partition.
+type Partition struct {
+	cfg PartitionConfig
+
+	mu   sync.Mutex
+	ring *hashRing
+
+	// The following are only used by the watcher while holding its sweep lock. The applied ring is the one the watcher's
+	// cache reflects, and while handovers are outstanding, waiting holds the members that haven't finished handing over
+	// and previous holds the ring they were handing over from, if it is known. Once the handover timeout has passed,
+	// stale is cleared after removing the handover files that no member will read again, including the markers of the
+	// departed members.
+	applied   *hashRing
+	previous  *hashRing
+	waiting   map[string]bool
+	changedAt time.Time
+	stale     bool
+	departed  []string
+}
+
+// handover is the content of a handover file.
+type handover struct {
+	Key   string          `json:"key"`
+	Files []SnapshotEntry `json:"files"`
+}
+
+// NewPartition creates a partition.
+func NewPartition(cfg PartitionConfig) (*Partition, error) {
+	if cfg.Self == "" {
+		return nil, errors.New("partition member name is required")
+	}
+	if cfg.Level <= 0 {
+		cfg.Level = 1
+	}
+	if cfg.Replicas <= 0 {
+		cfg.Replicas = DefaultPartitionReplicas
+	}
+	if cfg.HandoverTimeout <= 0 {
+		cfg.HandoverTimeout = DefaultHandoverTimeout
+	}
+	if cfg.HandoverDir != "" {
+		if err := os.MkdirAll(cfg.HandoverDir, 0o755); err != nil {
+			return nil, fmt.Errorf("create handover dir: %w", err)
+		}
+	}
+	return &Partition{cfg: cfg, ring: newHashRing(cfg.Members, cfg.Replicas)}, nil
+}
+
+// SetMembers changes the members that the directories are distributed between. It takes effect before the next sweep
+// or rescan begins.
+func (p *Partition) SetMembers(members []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ring = newHashRing(members, p.cfg.Replicas)
+}
+
+func (p *Partition) current() *hashRing {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ring
+}
+
+// key returns the partition key for a directory relative to the sub-root, and whether the directory is deep enough to
+// have one. Files are keyed by the directory that contains them, and files above the partition level share the empty
+// key.
+func (p *Partition) key(dir string) (string, bool) {
+	if dir == "." || dir == "" {
+		return "", false
+	}
+	parts := strings.Split(dir, "/")
+	if len(parts) < p.cfg.Level {
+		return "", false
+	}
+	return strings.Join(parts[:p.cfg.Level], "/"), true
+}
+
+// sweepsDir returns true if a directory relative to the sub-root should be swept by this instance.
+func (p *Partition) sweepsDir(dir string) bool {
+	key, ok := p.key(dir)
+	if !ok {
+		return true // Shallower directories are swept by everyone to reach the partitioned ones
+	}
+	return p.sweeps(key)
+}
+
+// sweepsFile returns true if a file relative to the sub-root should be reported by this instance.
+func (p *Partition) sweepsFile(name string) bool {
+	key, _ := p.key(path.Dir(name))
+	return p.sweeps(key)
+}
+
+// sweeps returns true if this instance owns a partition, and isn't waiting for its previous owner to hand it over.
+func (p *Partition) sweeps(key string) bool {
+	if p.applied.owner(key) != p.cfg.Self {
+		return false
+	}
+	if len(p.waiting) == 0 {
+		return true
+	}
+	if p.previous == nil {
+		return false // The previous owner isn't known, so wait for everyone
+	}
+	previous := p.previous.owner(key)
+	return previous == p.cfg.Self || !p.waiting[previous]
+}
+
+// applyPartition hands over the directories this instance no longer owns when the members change, and takes over the
+// directories handed over to it. It must be called while holding the sweep lock.
+func (wd *watcher) applyPartition(ctx context.Context) error {
+	p := wd.partition
+	if p == nil {
+		return nil
+	}
+
+	ring := p.current()
+	switch {
+	case p.applied == nil:
+		// Until the other members say otherwise, any of them could have owned this instance's directories
+		p.applied = ring
+		p.startHandover(nil)
+		if err := p.writeDone(); err != nil {
+			return err
+		}
+	case ring.epoch != p.applied.epoch:
+		if err := wd.handOver(ctx, ring); err != nil {
+			return err
+		}
+		previous := p.applied
+		p.applied = ring
+		p.startHandover(previous)
+		if err := p.writeDone(); err != nil {
+			return err
+		}
+		wd.generation++
+	}
+	if p.stale && time.Since(p.changedAt) >= p.cfg.HandoverTimeout {
+		p.removeStaleHandovers()
+	}
+	if len(p.waiting) == 0 {
+		return nil
+	}
+
+	// Check which members have finished before reading their handovers, so that none are missed
+	for member := range p.waiting {
+		if _, err := os.Stat(p.donePath(member)); err == nil {
+			delete(p.waiting, member)
+		}
+	}
+	if len(p.waiting) > 0 && time.Since(p.changedAt) >= p.cfg.HandoverTimeout {
+		wd.logger.Printf("timed out waiting for partitions to be handed over by %d members", len(p.waiting))
+		clear(p.waiting)
+	}
+	if err := wd.takeOver(); err != nil {
+		return err
+	}
+	if len(p.waiting) == 0 {
+		p.previous = nil
+	}
+	return nil
+}
+
+// startHandover begins waiting for the other members to hand over their directories.
+func (p *Partition) startHandover(previous *hashRing) {
+	p.previous = previous
+	p.waiting = make(map[string]bool)
+	p.changedAt = time.Now()
+	if p.cfg.HandoverDir == "" {
+		return // There is nothing to wait for
+	}
+	p.stale = true
+	p.departed = nil
+	if previous != nil {
+		for _, member := range previous.members {
+			if _, found := slices.BinarySearch(p.applied.members, member); !found {
+				p.departed = append(p.departed, member)
+			}
+		}
+	}
+	// Members that are leaving hand over their directories too, unless they have stopped, which is only known once the
+	// timeout expires
+	members := p.applied.members
+	if previous != nil {
+		members = append(slices.Clone(members), previous.members...)
+	}
+	for _, member := range members {
+		if member != p.cfg.Self {
+			p.waiting[member] = true
+		}
+	}
+}
+
+// handOver writes the files known for each partition that moves to another member to the handover directory, then
+// removes them from the cache.
+func (wd *watcher) handOver(ctx context.Context, ring *hashRing) error {
+	p := wd.partition
+	var entries []SnapshotEntry
+	if err := wd.snapshotDir(ctx, ".", wd.cache, &entries); err != nil {
+		return err
+	}
+
+	// Group the files by partition, keeping the ones that move away
+	lost := make(map[string][]SnapshotEntry)
+	for _, entry := range entries {
+		rel, ok := wd.trimSubRoot(entry.Path)
+		if !ok {
+			continue
+		}
+		key, _ := p.key(path.Dir(rel))
+		if p.applied.owner(key) == p.cfg.Self && ring.owner(key) != p.cfg.Self {
+			lost[key] = append(lost[key], entry)
+		}
+	}
+
+	for key, files := range lost {
+		if p.cfg.HandoverDir != "" {
+			if err := writeJSONFile(p.handoverPath(ring, key), handover{Key: key, Files: files}); err != nil {
+				return fmt.Errorf("write handover: %w", err)
+			}
+		}
+		if key != "" {
+			wd.forget(key)
+			continue
+		}
+		for _, file := range files {
+			if rel, ok := wd.trimSubRoot(file.Path); ok {
+				wd.forget(rel)
+			}
+		}
+	}
+	return nil
+}
+
+// takeOver restores the files handed over to this instance into the cache.
+func (wd *watcher) takeOver() error {
+	p := wd.partition
+	if p.cfg.HandoverDir == "" {
+		return nil
+	}
+	names, err := filepath.Glob(filepath.Join(p.cfg.HandoverDir, p.applied.epoch+"-*.json"))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("read handover: %w", err)
+		}
+		var h handover
+		if err := json.Unmarshal(data, &h); err != nil {
+			return fmt.Errorf("decode handover %s: %w", name, err)
+		}
+		if p.applied.owner(h.Key) != p.cfg.Self {
+			continue
+		}
+		wd.restore(h.Files)
+		wd.generation++
+		if err := os.Remove(name); err != nil {
+			return fmt.Errorf("remove handover: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeDone records that this instance has handed over everything it no longer owns, and removes its records for
+// earlier member lists.
+func (p *Partition) writeDone() error {
+	if p.cfg.HandoverDir == "" {
+		return nil
+	}
+	donePath := p.donePath(p.cfg.Self)
+	old, err := filepath.Glob(filepath.Join(p.cfg.HandoverDir, "*-"+hashString(p.cfg.Self)+".done"))
+	if err != nil {
+		return err
+	}
+	for _, name := range old {
+		if name != donePath {
+			os.Remove(name)
+		}
+	}
+	if err := os.WriteFile(donePath, nil, 0o644); err != nil {
+		return fmt.Errorf("write handover marker: %w", err)
+	}
+	return nil
+}
+
+// removeStaleHandovers removes the handover files and markers written for other member lists, along with the markers
+// of members that have left. It is called once the handover timeout has passed, by which time every member has either
+// taken over its partitions or stopped waiting for them.
+func (p *Partition) removeStaleHandovers() {
+	p.stale = false
+	defer func() { p.departed = nil }()
+	entries, err := os.ReadDir(p.cfg.HandoverDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".json") && !strings.HasSuffix(name, ".done") {
+			continue
+		}
+		stale := !strings.HasPrefix(name, p.applied.epoch+"-")
+		for _, member := range p.departed {
+			stale = stale || name == filepath.Base(p.donePath(member))
+		}
+		if stale {
+			os.Remove(filepath.Join(p.cfg.HandoverDir, name))
+		}
+	}
+}
+
+// donePath returns the marker written by a member once it has handed over its partitions for the applied ring.
+func (p *Partition) donePath(member string) string {
+	return filepath.Join(p.cfg.HandoverDir, p.applied.epoch+"-"+hashString(member)+".done")
+}
+
+// handoverPath returns the file that a partition is handed over in, when moving to the given ring.
+func (p *Partition) handoverPath(ring *hashRing, key string) string {
+	return filepath.Join(p.cfg.HandoverDir, ring.epoch+"-"+hashString(key)+".json")
+}
+
+// writeJSONFile writes a value to a file as JSON, via a temporary file so that readers never see a partial file.
+func writeJSONFile(name string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(name+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
+
+// hashRing assigns keys to members by consistent hashing, so that changing the members only moves the keys owned by
+// the members that were added or removed.
+type hashRing struct {
+	members []string
+	points  []ringPoint
+
+	// epoch identifies the member list, and is the same for every instance given the same members
+	epoch string
+}
+
+type ringPoint struct {
+	hash   uint64
+	member string
+}
+
+func newHashRing(members []string, replicas int) *hashRing {
+	members = slices.Clone(members)
+	slices.Sort(members)
+	members = slices.Compact(members)
+
+	ring := &hashRing{members: members, epoch: hashString(strings.Join(members, "\n"))}
+	for _, member := range members {
+		for i := range replicas {
+			ring.points = append(ring.points, ringPoint{hash: hash64(member + "#" + strconv.Itoa(i)), member: member})
+		}
+	}
+	slices.SortFunc(ring.points, func(a, b ringPoint) int {
+		switch {
+		case a.hash < b.hash:
+			return -1
+		case a.hash > b.hash:
+			return 1
+		default:
+			return strings.Compare(a.member, b.member)
+		}
+	})
+	return ring
+}
+
+// owner returns the member that owns a key, or an empty string if there are no members.
+func (r *hashRing) owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hash64(key)
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= h
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].member
+}
+
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+func hashString(s string) string {
+	return strconv.FormatUint(hash64(s), 16)
+}
@@ -0,0 +1,121 @@
+package watchdir_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spiretechnology/go-watchdir/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartition(t *testing.T) {
+	t.Run("hands over directories when members join", func(t *testing.T) {
+		dir := t.TempDir()
+		handoverDir := t.TempDir()
+		var dirs []string
+		for i := range 20 {
+			dirs = append(dirs, fmt.Sprintf("dir%d", i))
+			writeFile(t, filepath.Join(dir, dirs[i], "old"), "")
+		}
+		writeFile(t, filepath.Join(dir, "top"), "")
+
+		newWatcher := func(self string, members ...string) (*watchdir.Partition, watchdir.Watcher) {
+			partition, err := watchdir.NewPartition(watchdir.PartitionConfig{
+				Self:        self,
+				Members:     members,
+				HandoverDir: handoverDir,
+			})
+			require.NoError(t, err, "error creating partition")
+			return partition, watchdir.New(os.DirFS(dir), watchdir.WithWriteStabilityThreshold(0), watchdir.WithPartition(partition))
+		}
+
+		// A single member sweeps everything
+		partitionA, wdA := newWatcher("a", "a")
+		events, err := sweepAndCollectEvents(t, wdA)
+		require.NoError(t, err, "error sweeping")
+		require.Len(t, events[watchdir.FileAdded], 21, "wrong number of add events")
+
+		// A second member joins, and waits for the first to hand over its directories
+		partitionA.SetMembers([]string{"a", "b"})
+		partitionB, wdB := newWatcher("b", "a", "b")
+		events, err = sweepAndCollectEvents(t, wdB)
+		require.NoError(t, err, "error sweeping")
+		require.Empty(t, events, "should not sweep before the handover")
+
+		// Once the directories are handed over, each new file is reported by exactly one member
+		var expected []string
+		for _, name := range dirs {
+			writeFile(t, filepath.Join(dir, name, "new"), "")
+			expected = append(expected, name+"/new")
+		}
+		eventsA, err := sweepAndCollectEvents(t, wdA)
+		require.NoError(t, err, "error sweeping")
+		eventsB, err := sweepAndCollectEvents(t, wdB)
+		require.NoError(t, err, "error sweeping")
+		require.NotEmpty(t, eventsA[watchdir.FileAdded], "first member should keep some directories")
+		require.NotEmpty(t, eventsB[watchdir.FileAdded], "second member should take over some directories")
+		require.ElementsMatch(t, expected, append(eventsA[watchdir.FileAdded], eventsB[watchdir.FileAdded]...), "wrong files added")
+		require.Empty(t, eventsA[watchdir.FileRemoved], "handing over should not report removals")
+
+		// Once a member leaves, the other takes back its directories without reporting them again
+		partitionA.SetMembers([]string{"a"})
+		partitionB.SetMembers([]string{"a"})
+		for _, wd := range []watchdir.Watcher{wdA, wdB, wdA} {
+			events, err = sweepAndCollectEvents(t, wd)
+			require.NoError(t, err, "error sweeping")
+			require.Empty(t, events, "should not report handed over files")
+		}
+		expected = nil
+		for _, name := range dirs {
+			writeFile(t, filepath.Join(dir, name, "later"), "")
+			expected = append(expected, name+"/later")
+		}
+		events, err = sweepAndCollectEvents(t, wdA)
+		require.NoError(t, err, "error sweeping")
+		require.ElementsMatch(t, expected, events[watchdir.FileAdded], "remaining member should sweep every directory")
+	})
+	t.Run("removes handover files once members have left", func(t *testing.T) {
+		dir := t.TempDir()
+		handoverDir := t.TempDir()
+		for i := range 10 {
+			writeFile(t, filepath.Join(dir, fmt.Sprintf("dir%d", i), "old"), "")
+		}
+		newWatcher := func(self string) (*watchdir.Partition, watchdir.Watcher) {
+			partition, err := watchdir.NewPartition(watchdir.PartitionConfig{
+				Self:            self,
+				Members:         []string{"a", "b"},
+				HandoverDir:     handoverDir,
+				HandoverTimeout: 100 * time.Millisecond,
+			})
+			require.NoError(t, err, "error creating partition")
+			return partition, watchdir.New(os.DirFS(dir), watchdir.WithWriteStabilityThreshold(0), watchdir.WithPartition(partition))
+		}
+		partitionA, wdA := newWatcher("a")
+		partitionB, wdB := newWatcher("b")
+		for _, wd := range []watchdir.Watcher{wdA, wdB, wdA} {
+			_, err := sweepAndCollectEvents(t, wd)
+			require.NoError(t, err, "error sweeping")
+		}
+
+		// The second member leaves, handing its directories back to the first
+		partitionA.SetMembers([]string{"a"})
+		partitionB.SetMembers([]string{"a"})
+		for _, wd := range []watchdir.Watcher{wdB, wdA} {
+			events, err := sweepAndCollectEvents(t, wd)
+			require.NoError(t, err, "error sweeping")
+			require.Empty(t, events, "should not report handed over files")
+		}
+
+		// Once the handover timeout has passed, only the remaining member's marker is left
+		time.Sleep(150 * time.Millisecond)
+		_, err := sweepAndCollectEvents(t, wdA)
+		require.NoError(t, err, "error sweeping")
+		entries, err := os.ReadDir(handoverDir)
+		require.NoError(t, err, "error reading handover dir")
+		require.Len(t, entries, 1, "stale handover files should be removed")
+		require.Contains(t, entries[0].Name(), ".done", "remaining member's marker should be kept")
+	})
+}
@@ -0,0 +1,169 @@
+package watchdir
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"path"
+	"strings"
+	"time"
+)
+
+func (wd *watcher) Forget(name string) {
+	wd.forgetMu.Lock()
+	defer wd.forgetMu.Unlock()
+	wd.forgotten = append(wd.forgotten, normalizePath(name))
+}
+
+func (wd *watcher) Rescan(ctx context.Context, name string, chanEvents chan<- Event) (reterr error) {
+	wd.sweepMu.Lock()
+	defer wd.sweepMu.Unlock()
+
+	// Only the leader rescans, like it is the only one that sweeps
+	if standby, err := wd.standby(); standby || err != nil {
+		return err
+	}
+
+	startTime := time.Now()
+	wd.logger.Printf("rescan of %q started", name)
+	defer func() {
+		duration := time.Since(startTime)
+		if reterr != nil {
+			wd.logger.Printf("rescan of %q took %s, returned error: %+v", name, duration, reterr)
+		} else {
+			wd.logger.Printf("rescan of %q took %s, completed successfully", name, duration)
+		}
+	}()
+
+	// Get the fsys for the sweep, which can be a sub-fs
+	fsys, err := wd.getSweepFS()
+	if err != nil {
+		return err
+	}
+
+	// Find the path within the sweep fs
+	rel, ok := wd.trimSubRoot(normalizePath(name))
+	if !ok {
+		return fmt.Errorf("rescan %q: path is outside of the sub-root", name)
+	}
+
+	// If the path is a file, rescan the directory that contains it. If it no longer exists, rescan its nearest existing
+	// ancestor instead, so that its removal is reported.
+	pathPrefix := "."
+	if rel != "" {
+		pathPrefix = rel
+	}
+	for {
+		stat, err := fs.Stat(wd.fsys, wd.prependSubRoot(pathPrefix))
+		if errors.Is(err, fs.ErrNotExist) && pathPrefix != "." {
+			pathPrefix = path.Dir(pathPrefix)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("rescan %q: %w", name, err)
+		}
+		if !stat.IsDir() {
+			pathPrefix = path.Dir(pathPrefix)
+		}
+		break
+	}
+
+	// Apply any pending forgets and partition changes, so they're reflected in the rescan
+	wd.applyForgotten()
+	if err := wd.applyPartition(ctx); err != nil {
+		return err
+	}
+	wd.sweepID++
+
+	// Sweep the subtree, creating cache entries for any directories that haven't been swept yet
+	cs := wd.newChangeSet(false)
+	cache, depth := wd.cache, uint(0)
+	if pathPrefix != "." {
+		for _, part := range strings.Split(pathPrefix, "/") {
+			child := cache.children[part]
+			if child == nil {
+				child = newDirCache()
+				cs.addUpdate(dirUpdate{cache: cache, added: map[string]*dirCache{part: child}})
+			}
+			cache = child
+			depth++
+		}
+	}
+	if err := wd.plan(ctx, fsys, cs, depth, pathPrefix, cache, false); err != nil {
+		return err
+	}
+	cs.sort()
+	_, err = wd.apply(ctx, cs, chanEvents)
+	return err
+}
+
+// applyForgotten removes all paths passed to Forget from the cache. It must be called while holding the sweep lock.
+func (wd *watcher) applyForgotten() {
+	wd.forgetMu.Lock()
+	forgotten := wd.forgotten
+	wd.forgotten = nil
+	wd.forgetMu.Unlock()
+
+	for _, name := range forgotten {
+		if rel, ok := wd.trimSubRoot(name); ok {
+			wd.forget(rel)
+		}
+	}
+	if len(forgotten) > 0 {
+		wd.generation++
+	}
+}
+
+// forget removes a file or directory from the cache, so the next sweep treats it as new.
+func (wd *watcher) forget(rel string) {
+	// Forgetting the root resets the entire cache
+	if rel == "" {
+		wd.cache.mu.Lock()
+		wd.cache.entries = make(map[string]fs.DirEntry)
+		wd.cache.children = make(map[string]*dirCache)
+		wd.cache.mu.Unlock()
+		return
+	}
+
+	// Find the cache for the parent directory
+	parent := wd.cache
+	if dir := path.Dir(rel); dir != "." {
+		for _, part := range strings.Split(dir, "/") {
+			parent.mu.RLock()
+			child := parent.children[part]
+			parent.mu.RUnlock()
+			if child == nil {
+				return // Nothing is cached for this path
+			}
+			parent = child
+		}
+	}
+
+	// Replace a directory with an empty cache, or remove a file from the entries. The entries map is replaced rather than
+	// modified, since readers may be holding a reference to it.
+	name := path.Base(rel)
+	parent.mu.Lock()
+	defer parent.mu.Unlock()
+	if parent.children[name] != nil {
+		parent.children[name] = newDirCache()
+	} else if _, ok := parent.entries[name]; ok {
+		entries := maps.Clone(parent.entries)
+		delete(entries, name)
+		parent.entries = entries
+	}
+}
+
+// trimSubRoot converts a normalized path relative to the watched file system into a path relative to the sub-root. It
+// returns false if the path is outside of the sub-root.
+func (wd *watcher) trimSubRoot(name string) (string, bool) {
+	switch {
+	case wd.subRoot == "":
+		return name, true
+	case name == wd.subRoot:
+		return "", true
+	default:
+		return strings.CutPrefix(name, wd.subRoot+"/")
+	}
+}
@@ -0,0 +1,266 @@
+package watchdir
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SnapshotEntry describes a single file within a snapshot.
+type SnapshotEntry struct {
+	Path    string      `json:"path"`
+	Size    int64       `json:"size"`
+	ModTime time.Time   `json:"mtime"`
+	Mode    fs.FileMode `json:"mode"`
+}
+
+// equal returns true if both entries describe the same version of a file.
+func (e SnapshotEntry) equal(other SnapshotEntry) bool {
+	return e.Size == other.Size && e.ModTime.Equal(other.ModTime) && e.Mode == other.Mode
+}
+
+// Snapshot is an immutable view of the files known to a watcher at a point in time.
+type Snapshot struct {
+	entries []SnapshotEntry
+}
+
+// NewSnapshot creates a snapshot from the provided entries. If multiple entries have the same path, the last one wins.
+func NewSnapshot(entries ...SnapshotEntry) Snapshot {
+	sorted := slices.Clone(entries)
+	slices.SortStableFunc(sorted, func(a, b SnapshotEntry) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+
+	// Remove duplicate paths, keeping the last occurrence of each
+	deduped := sorted[:0]
+	for _, entry := range sorted {
+		if n := len(deduped); n > 0 && deduped[n-1].Path == entry.Path {
+			deduped[n-1] = entry
+			continue
+		}
+		deduped = append(deduped, entry)
+	}
+	return Snapshot{entries: deduped}
+}
+
+// Len returns the number of files in the snapshot.
+func (s Snapshot) Len() int {
+	return len(s.entries)
+}
+
+// Entries returns the files in the snapshot, sorted by path.
+func (s Snapshot) Entries() []SnapshotEntry {
+	return slices.Clone(s.entries)
+}
+
+// Lookup returns the entry for the given path, if it exists in the snapshot.
+func (s Snapshot) Lookup(name string) (SnapshotEntry, bool) {
+	i, found := slices.BinarySearchFunc(s.entries, name, func(entry SnapshotEntry, name string) int {
+		return strings.Compare(entry.Path, name)
+	})
+	if !found {
+		return SnapshotEntry{}, false
+	}
+	return s.entries[i], true
+}
+
+type snapshotJSON struct {
+	Files []SnapshotEntry `json:"files"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (s Snapshot) MarshalJSON() ([]byte, error) {
+	files := s.entries
+	if files == nil {
+		files = []SnapshotEntry{}
+	}
+	return json.Marshal(snapshotJSON{Files: files})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (s *Snapshot) UnmarshalJSON(data []byte) error {
+	var decoded snapshotJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*s = NewSnapshot(decoded.Files...)
+	return nil
+}
+
+// Diff compares two snapshots and returns the events that describe the changes from a to b, sorted by path. Files that
+// exist in both snapshots are reported as FileModified if their size, modification time or mode differ.
+func Diff(a, b Snapshot) []Event {
+	var events []Event
+	i, j := 0, 0
+	for i < len(a.entries) || j < len(b.entries) {
+		switch {
+		case j == len(b.entries) || (i < len(a.entries) && a.entries[i].Path < b.entries[j].Path):
+			// The file only exists in the old snapshot
+			events = append(events, Event{Type: FileRemoved, File: a.entries[i].Path})
+			i++
+		case i == len(a.entries) || b.entries[j].Path < a.entries[i].Path:
+			// The file only exists in the new snapshot
+			events = append(events, Event{Type: FileAdded, File: b.entries[j].Path})
+			j++
+		default:
+			// The file exists in both snapshots, and is modified if its metadata changed
+			if !a.entries[i].equal(b.entries[j]) {
+				events = append(events, Event{Type: FileModified, File: b.entries[j].Path})
+			}
+			i++
+			j++
+		}
+	}
+	return events
+}
+
+func (wd *watcher) Snapshot(ctx context.Context) (Snapshot, error) {
+	var entries []SnapshotEntry
+	if err := wd.snapshotDir(ctx, ".", wd.cache, &entries); err != nil {
+		return Snapshot{}, err
+	}
+	return NewSnapshot(entries...), nil
+}
+
+func (wd *watcher) Len() int {
+	return lenDir(wd.cache)
+}
+
+// lenDir counts the files in a directory's cache and those of its subdirectories.
+func lenDir(cache *dirCache) int {
+	cache.mu.RLock()
+	cachedEntries := cache.entries
+	children := maps.Clone(cache.children)
+	cache.mu.RUnlock()
+
+	var count int
+	for name, entry := range cachedEntries {
+		if _, excluded := entry.(excludedEntry); excluded {
+			continue
+		}
+		if !entry.IsDir() {
+			count++
+		} else if child := children[name]; child != nil {
+			count += lenDir(child)
+		}
+	}
+	return count
+}
+
+func (wd *watcher) snapshotDir(ctx context.Context, pathPrefix string, cache *dirCache, entries *[]SnapshotEntry) error {
+	// Breakout if the context is cancelled.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Grab the current state of the directory, so the sweep isn't blocked while we read it
+	cache.mu.RLock()
+	cachedEntries := cache.entries
+	children := maps.Clone(cache.children)
+	cache.mu.RUnlock()
+
+	for name, entry := range cachedEntries {
+		// Skip files that the sweep found didn't pass the file filter
+		if _, excluded := entry.(excludedEntry); excluded {
+			continue
+		}
+		filename := path.Join(pathPrefix, name)
+		if entry.IsDir() {
+			if child := children[name]; child != nil {
+				if err := wd.snapshotDir(ctx, filename, child, entries); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		// The metadata was captured by the sweep that recorded the file, so the snapshot matches the watcher's view even
+		// if the file has changed since
+		stat, err := entry.Info()
+		if err != nil {
+			return fmt.Errorf("stat entry %q: %w", filename, err)
+		}
+		*entries = append(*entries, SnapshotEntry{
+			Path:    wd.prependSubRoot(filename),
+			Size:    stat.Size(),
+			ModTime: stat.ModTime(),
+			Mode:    stat.Mode(),
+		})
+	}
+	return nil
+}
+
+// restore adds files to the cache, as if they had been found by a sweep. Each directory's entries are replaced once
+// with all of its restored files, since readers may be holding a reference to them. It must be called while holding
+// the sweep lock.
+func (wd *watcher) restore(files []SnapshotEntry) {
+	restored := make(map[*dirCache]map[string]fs.DirEntry)
+	add := func(cache *dirCache, name string, entry fs.DirEntry) {
+		if restored[cache] == nil {
+			restored[cache] = make(map[string]fs.DirEntry)
+		}
+		restored[cache][name] = entry
+	}
+
+	for _, file := range files {
+		rel, ok := wd.trimSubRoot(normalizePath(file.Path))
+		if !ok || rel == "" {
+			continue
+		}
+
+		// Create the cache for each directory leading to the file
+		cache := wd.cache
+		parts := strings.Split(rel, "/")
+		for _, part := range parts[:len(parts)-1] {
+			cache.mu.Lock()
+			child := cache.children[part]
+			if child == nil {
+				child = newDirCache()
+				cache.children[part] = child
+			}
+			known := cache.entries[part] != nil
+			cache.mu.Unlock()
+			if !known {
+				add(cache, part, dirEntry(part))
+			}
+			cache = child
+		}
+
+		name := parts[len(parts)-1]
+		add(cache, name, fs.FileInfoToDirEntry(snapshotFileInfo{
+			name:    name,
+			size:    file.Size,
+			mode:    file.Mode,
+			modTime: file.ModTime,
+		}))
+	}
+
+	for cache, entries := range restored {
+		cache.mu.Lock()
+		merged := maps.Clone(cache.entries)
+		maps.Copy(merged, entries)
+		cache.entries = merged
+		cache.mu.Unlock()
+	}
+}
+
+// snapshotFileInfo implements fs.FileInfo for a file restored from a snapshot.
+type snapshotFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (fi snapshotFileInfo) Name() string       { return fi.name }
+func (fi snapshotFileInfo) Size() int64        { return fi.size }
+func (fi snapshotFileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi snapshotFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi snapshotFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi snapshotFileInfo) Sys() any           { return nil }
@@ -0,0 +1,177 @@
+package watchdir_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spiretechnology/go-memfs"
+	"github.com/spiretechnology/go-watchdir/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func snapshotPaths(snap watchdir.Snapshot) []string {
+	var paths []string
+	for _, entry := range snap.Entries() {
+		paths = append(paths, entry.Path)
+	}
+	return paths
+}
+
+func TestSnapshot(t *testing.T) {
+	t.Run("reflects the files known to the watcher", func(t *testing.T) {
+		fsys := memfs.FS{
+			"hello/foo":   memfs.File("hello"),
+			"hello/bar/a": memfs.File("world"),
+			"world/baz":   memfs.Dir{},
+		}
+		wd := watchdir.NewDirWatcher(fsys,
+			watchdir.WithWriteStabilityThreshold(0),
+			watchdir.WithSubRoot("hello"),
+		)
+
+		// Nothing is known before the first sweep
+		snap, err := wd.Snapshot(context.Background())
+		require.NoError(t, err, "error taking snapshot")
+		require.Equal(t, 0, snap.Len(), "wrong number of files")
+
+		_, err = sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+
+		snap, err = wd.Snapshot(context.Background())
+		require.NoError(t, err, "error taking snapshot")
+		require.Equal(t, []string{"hello/bar/a", "hello/foo"}, snapshotPaths(snap), "wrong files in snapshot")
+
+		entry, ok := snap.Lookup("hello/foo")
+		require.True(t, ok, "file missing from snapshot")
+		require.Equal(t, int64(5), entry.Size, "wrong file size")
+	})
+	t.Run("counts and snapshots the files known to the watcher without filtering them again", func(t *testing.T) {
+		fsys := memfs.FS{
+			"foo":       memfs.File("hello"),
+			"foo.part":  memfs.File("partial"),
+			"sub/bar":   memfs.File("world"),
+			"sub/a.tmp": memfs.File("temp"),
+			"empty":     memfs.Dir{},
+		}
+		var filterCalls atomic.Int64
+		wd := watchdir.NewDirWatcher(fsys,
+			watchdir.WithWriteStabilityThreshold(0),
+			watchdir.WithFileFilter(watchdir.FilterFunc(func(ctx context.Context, filename string) (bool, error) {
+				filterCalls.Add(1)
+				return filepath.Ext(filename) == "", nil
+			})),
+		)
+		require.Equal(t, 0, wd.Len(), "nothing should be known before the first sweep")
+
+		_, err := sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		calls := filterCalls.Load()
+		require.Equal(t, 2, wd.Len(), "filtered files should not be counted")
+		require.Equal(t, calls, filterCalls.Load(), "counting should not call the filter")
+
+		delete(fsys, "sub/bar")
+		_, err = sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		calls = filterCalls.Load()
+		snap, err := wd.Snapshot(context.Background())
+		require.NoError(t, err, "error taking snapshot")
+		require.Equal(t, calls, filterCalls.Load(), "snapshots should not call the filter")
+		require.Equal(t, []string{"foo"}, snapshotPaths(snap), "filtered files should not be in the snapshot")
+		require.Equal(t, snap.Len(), wd.Len(), "count should match the snapshot")
+		require.Equal(t, 1, wd.Len(), "removed files should not be counted")
+	})
+	t.Run("round trips through json", func(t *testing.T) {
+		snap := watchdir.NewSnapshot(
+			watchdir.SnapshotEntry{Path: "b", Size: 2, ModTime: time.Unix(2, 0).UTC()},
+			watchdir.SnapshotEntry{Path: "a", Size: 1, ModTime: time.Unix(1, 0).UTC()},
+		)
+		data, err := json.Marshal(snap)
+		require.NoError(t, err, "error marshaling snapshot")
+
+		var decoded watchdir.Snapshot
+		require.NoError(t, json.Unmarshal(data, &decoded), "error unmarshaling snapshot")
+		require.Equal(t, snap.Entries(), decoded.Entries(), "wrong entries after round trip")
+		require.Equal(t, []string{"a", "b"}, snapshotPaths(decoded), "entries should be sorted")
+	})
+	t.Run("diff reports added and removed files", func(t *testing.T) {
+		a := watchdir.NewSnapshot(
+			watchdir.SnapshotEntry{Path: "foo"},
+			watchdir.SnapshotEntry{Path: "hello/bar"},
+			watchdir.SnapshotEntry{Path: "hello/baz"},
+		)
+		b := watchdir.NewSnapshot(
+			watchdir.SnapshotEntry{Path: "hello/bar"},
+			watchdir.SnapshotEntry{Path: "hello/qux"},
+			watchdir.SnapshotEntry{Path: "zzz"},
+		)
+		require.Equal(t, []watchdir.Event{
+			{Type: watchdir.FileRemoved, File: "foo"},
+			{Type: watchdir.FileRemoved, File: "hello/baz"},
+			{Type: watchdir.FileAdded, File: "hello/qux"},
+			{Type: watchdir.FileAdded, File: "zzz"},
+		}, watchdir.Diff(a, b), "wrong diff")
+		require.Empty(t, watchdir.Diff(b, b), "identical snapshots should have no diff")
+	})
+	t.Run("diff reports modified files", func(t *testing.T) {
+		a := watchdir.NewSnapshot(
+			watchdir.SnapshotEntry{Path: "foo", Size: 1, ModTime: time.Unix(1, 0)},
+			watchdir.SnapshotEntry{Path: "bar", Size: 1, ModTime: time.Unix(1, 0)},
+			watchdir.SnapshotEntry{Path: "baz", Size: 1, ModTime: time.Unix(1, 0)},
+		)
+		b := watchdir.NewSnapshot(
+			watchdir.SnapshotEntry{Path: "foo", Size: 2, ModTime: time.Unix(1, 0)},
+			watchdir.SnapshotEntry{Path: "bar", Size: 1, ModTime: time.Unix(2, 0)},
+			watchdir.SnapshotEntry{Path: "baz", Size: 1, ModTime: time.Unix(1, 0).UTC()},
+		)
+		require.Equal(t, []watchdir.Event{
+			{Type: watchdir.FileModified, File: "bar"},
+			{Type: watchdir.FileModified, File: "foo"},
+		}, watchdir.Diff(a, b), "wrong diff")
+	})
+	t.Run("restores a watcher from a snapshot", func(t *testing.T) {
+		fsys := memfs.FS{
+			"foo":         memfs.File("hello"),
+			"hello/bar":   memfs.File("world"),
+			"hello/baz/a": memfs.File("golang"),
+		}
+		wd := watchdir.NewDirWatcher(fsys, watchdir.WithWriteStabilityThreshold(0))
+		_, err := sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		snap, err := wd.Snapshot(context.Background())
+		require.NoError(t, err, "error taking snapshot")
+
+		// Change the files while no watcher is running
+		fsys["hello/qux"] = memfs.File("")
+		delete(fsys, "hello/baz/a")
+
+		// The restored watcher should only report the changes
+		wd = watchdir.NewDirWatcher(fsys,
+			watchdir.WithWriteStabilityThreshold(0),
+			watchdir.WithSnapshot(snap),
+		)
+		events, err := sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.ElementsMatch(t, []string{"hello/qux"}, events[watchdir.FileAdded], "wrong files added")
+		require.ElementsMatch(t, []string{"hello/baz/a"}, events[watchdir.FileRemoved], "wrong files removed")
+	})
+	t.Run("records metadata as of the sweep", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, "foo"), "hello")
+		wd := watchdir.NewDirWatcher(os.DirFS(dir), watchdir.WithWriteStabilityThreshold(0))
+		_, err := sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+
+		// A modification that no sweep has seen yet is left out of the snapshot
+		writeFile(t, filepath.Join(dir, "foo"), "hello world")
+		snap, err := wd.Snapshot(context.Background())
+		require.NoError(t, err, "error taking snapshot")
+		entry, ok := snap.Lookup("foo")
+		require.True(t, ok, "file should be in the snapshot")
+		require.EqualValues(t, 5, entry.Size, "snapshot should have the size as of the sweep")
+	})
+}
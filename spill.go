@@ -0,0 +1,229 @@
+package watchdir
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// spillSegmentSize is the number of events written to a spill segment before a new one is started.
+	spillSegmentSize = 10000
+
+	spillSegmentExt = ".log"
+)
+
+// spillSegment is a file of events in a spill log, one JSON event per line.
+type spillSegment struct {
+	seq     uint64
+	written int
+	read    int
+	acked   int
+}
+
+// spillLog is a first-in, first-out queue of events on disk, which an EventBuffer spills to when it is full. Events are
+// appended to segment files, which are removed once every event in them has been read and acknowledged as delivered.
+// Segments are named by a sequence number, which determines their order.
+//
+// Segments left by a previous run are read from the start, so events that were read but not yet delivered before a
+// crash or cancellation are delivered again, along with any that were delivered from the same segment.
+type spillLog struct {
+	dir      string
+	segments []spillSegment
+	len      int
+
+	// readSegment is the index of the segment being read. The segments before it have been read, but not every event in
+	// them has been acknowledged.
+	readSegment int
+
+	writer   *os.File
+	reader   *bufio.Reader
+	readFile *os.File
+}
+
+func openSpillLog(dir string) (*spillLog, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create spill dir: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read spill dir: %w", err)
+	}
+
+	// Find the segments left over from a previous run
+	l := &spillLog{dir: dir}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), spillSegmentExt)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		seq, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		l.segments = append(l.segments, spillSegment{seq: seq})
+	}
+	slices.SortFunc(l.segments, func(a, b spillSegment) int {
+		return cmp.Compare(a.seq, b.seq)
+	})
+	for i := range l.segments {
+		written, err := l.count(l.segments[i].seq)
+		if err != nil {
+			return nil, err
+		}
+		l.segments[i].written = written
+		l.len += written
+	}
+	return l, nil
+}
+
+// count returns the number of complete events in a segment. Only the last line can be incomplete, if the process
+// crashed while writing it.
+func (l *spillLog) count(seq uint64) (int, error) {
+	file, err := os.Open(l.filename(seq))
+	if err != nil {
+		return 0, fmt.Errorf("open spill segment: %w", err)
+	}
+	defer file.Close()
+
+	var count int
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			break
+		}
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("read spill segment: %w", err)
+	}
+	return count, nil
+}
+
+func (l *spillLog) filename(seq uint64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%020d%s", seq, spillSegmentExt))
+}
+
+// Len returns the number of events that haven't been read.
+func (l *spillLog) Len() int {
+	return l.len
+}
+
+// Push appends an event to the newest segment, starting a new one if it is full.
+func (l *spillLog) Push(event Event) error {
+	if l.writer != nil && l.segments[len(l.segments)-1].written >= spillSegmentSize {
+		if err := l.closeWriter(); err != nil {
+			return err
+		}
+	}
+	if l.writer == nil {
+		var seq uint64
+		if len(l.segments) > 0 {
+			seq = l.segments[len(l.segments)-1].seq + 1
+		}
+		file, err := os.OpenFile(l.filename(seq), os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("create spill segment: %w", err)
+		}
+		l.writer = file
+		l.segments = append(l.segments, spillSegment{seq: seq})
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("encode event: %w", err)
+	}
+	if _, err := l.writer.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write event: %w", err)
+	}
+	l.segments[len(l.segments)-1].written++
+	l.len++
+	return nil
+}
+
+// Pop reads the oldest unread event. The event stays in its segment until it is acknowledged with Ack. It must only be
+// called while Len is greater than zero.
+func (l *spillLog) Pop() (Event, error) {
+	segment := &l.segments[l.readSegment]
+	if l.reader == nil {
+		file, err := os.Open(l.filename(segment.seq))
+		if err != nil {
+			return Event{}, fmt.Errorf("open spill segment: %w", err)
+		}
+		l.readFile, l.reader = file, bufio.NewReader(file)
+	}
+
+	line, err := l.reader.ReadBytes('\n')
+	if err != nil {
+		return Event{}, fmt.Errorf("read spill segment: %w", err)
+	}
+	var event Event
+	if err := json.Unmarshal(line, &event); err != nil {
+		return Event{}, fmt.Errorf("decode event: %w", err)
+	}
+	segment.read++
+	l.len--
+
+	if segment.read == segment.written {
+		l.readFile.Close()
+		l.readFile, l.reader = nil, nil
+		if l.readSegment == len(l.segments)-1 && l.writer != nil {
+			// The newest segment has been read too, so the next event starts a new one
+			if err := l.closeWriter(); err != nil {
+				return Event{}, err
+			}
+		}
+		l.readSegment++
+	}
+	return event, nil
+}
+
+// Ack records that the oldest unacknowledged event has been delivered, and removes its segment once every event in it
+// has been delivered. Events must be acknowledged in the order they were read.
+func (l *spillLog) Ack() error {
+	segment := &l.segments[0]
+	segment.acked++
+	if segment.acked < segment.written {
+		return nil
+	}
+	if err := os.Remove(l.filename(segment.seq)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove spill segment: %w", err)
+	}
+	l.segments = l.segments[1:]
+	l.readSegment--
+	return nil
+}
+
+// Close syncs the newest segment to disk, and closes the open files.
+func (l *spillLog) Close() error {
+	if l.readFile != nil {
+		l.readFile.Close()
+		l.readFile, l.reader = nil, nil
+	}
+	return l.closeWriter()
+}
+
+// closeWriter syncs and closes the newest segment, so that the next event starts a new one.
+func (l *spillLog) closeWriter() error {
+	if l.writer == nil {
+		return nil
+	}
+	file := l.writer
+	l.writer = nil
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("sync spill segment: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("close spill segment: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package watchdir
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// batchQueue is a first-in, first-out queue of event batches.
+type batchQueue interface {
+	// Len returns the number of batches in the queue.
+	Len() int
+	// Peek returns the oldest batch in the queue, without removing it. If the batch can't be decoded, it is moved out of
+	// the queue and an error wrapping errBadBatch is returned, since it would fail the same way every time.
+	Peek() ([]Event, error)
+	// Push adds a batch to the end of the queue.
+	Push(batch []Event) error
+	// Pop removes the oldest batch from the queue.
+	Pop() error
+}
+
+// memoryQueue is a batchQueue that is held in memory.
+type memoryQueue struct {
+	batches [][]Event
+}
+
+func (q *memoryQueue) Len() int {
+	return len(q.batches)
+}
+
+func (q *memoryQueue) Peek() ([]Event, error) {
+	return q.batches[0], nil
+}
+
+func (q *memoryQueue) Push(batch []Event) error {
+	q.batches = append(q.batches, batch)
+	return nil
+}
+
+func (q *memoryQueue) Pop() error {
+	q.batches[0] = nil
+	q.batches = q.batches[1:]
+	return nil
+}
+
+// errBadBatch is returned by Peek for a batch that can't be decoded.
+var errBadBatch = errors.New("bad batch")
+
+// diskQueue is a batchQueue that stores each batch as a file in a directory, so that it survives restarts. Files are
+// named by a sequence number, which determines their order.
+type diskQueue struct {
+	dir  string
+	seqs []uint64
+}
+
+const (
+	diskQueueExt = ".json"
+
+	// diskQueueTempExt is added to the name of a batch while it is written, so a crash can't leave a partial batch in the
+	// queue.
+	diskQueueTempExt = ".tmp"
+
+	// diskQueueBadExt is added to the name of a batch that can't be decoded, which moves it out of the queue while
+	// keeping it for inspection.
+	diskQueueBadExt = ".bad"
+)
+
+func openDiskQueue(dir string) (*diskQueue, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create queue dir: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read queue dir: %w", err)
+	}
+
+	// Find the batches left over from a previous run, removing any that were only partially written
+	q := &diskQueue{dir: dir}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), diskQueueExt+diskQueueTempExt) && !entry.IsDir() {
+			if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil && !os.IsNotExist(err) {
+				return nil, fmt.Errorf("remove partial batch: %w", err)
+			}
+			continue
+		}
+		name, ok := strings.CutSuffix(entry.Name(), diskQueueExt)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		seq, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		q.seqs = append(q.seqs, seq)
+	}
+	slices.Sort(q.seqs)
+	return q, nil
+}
+
+func (q *diskQueue) filename(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", seq, diskQueueExt))
+}
+
+func (q *diskQueue) Len() int {
+	return len(q.seqs)
+}
+
+func (q *diskQueue) Peek() ([]Event, error) {
+	filename := q.filename(q.seqs[0])
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("read batch: %w", err)
+	}
+	var batch []Event
+	if err := json.Unmarshal(data, &batch); err != nil {
+		bad := filename + diskQueueBadExt
+		if err := os.Rename(filename, bad); err != nil {
+			return nil, fmt.Errorf("move bad batch: %w", err)
+		}
+		q.seqs = q.seqs[1:]
+		return nil, fmt.Errorf("%w moved to %s: %w", errBadBatch, bad, err)
+	}
+	return batch, nil
+}
+
+func (q *diskQueue) Push(batch []Event) error {
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("encode batch: %w", err)
+	}
+
+	// Write to a temporary file first, so a crash can't leave a partial batch in the queue
+	var seq uint64
+	if len(q.seqs) > 0 {
+		seq = q.seqs[len(q.seqs)-1] + 1
+	}
+	tmp := q.filename(seq) + diskQueueTempExt
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write batch: %w", err)
+	}
+	if err := os.Rename(tmp, q.filename(seq)); err != nil {
+		return fmt.Errorf("rename batch: %w", err)
+	}
+	q.seqs = append(q.seqs, seq)
+	return nil
+}
+
+func (q *diskQueue) Pop() error {
+	if err := os.Remove(q.filename(q.seqs[0])); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove batch: %w", err)
+	}
+	q.seqs = q.seqs[1:]
+	return nil
+}
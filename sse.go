@@ -0,0 +1,267 @@
+package watchdir
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSSEHistorySize is the default number of recent events kept so that reconnecting clients can resume.
+	DefaultSSEHistorySize = 1000
+
+	// DefaultSSEClientBuffer is the default number of events buffered for each client before it is disconnected.
+	DefaultSSEClientBuffer = 100
+
+	// DefaultSSEKeepAlive is the default time between keep-alive comments sent to idle clients.
+	DefaultSSEKeepAlive = 15 * time.Second
+
+	// SSEResetEvent is the event name of the message sent to a resuming client when some of the events it missed are no
+	// longer in the history. The client should resync, such as by comparing a snapshot, since the events that follow
+	// don't cover everything it missed.
+	SSEResetEvent = "reset"
+)
+
+// SSEConfig configures an SSEHandler.
+type SSEConfig struct {
+	// HistorySize is the number of recent events kept so that clients can resume with the Last-Event-ID header after
+	// reconnecting. Defaults to DefaultSSEHistorySize.
+	HistorySize int
+
+	// ClientBuffer is the number of events buffered for each client. A client that falls further behind is
+	// disconnected, and can resume from the history when it reconnects. Defaults to DefaultSSEClientBuffer.
+	ClientBuffer int
+
+	// KeepAlive is the time between comments sent to idle clients, so that proxies don't close the connection. Defaults
+	// to DefaultSSEKeepAlive.
+	KeepAlive time.Duration
+}
+
+// SSEHandler is an http.Handler that streams events to any number of clients as Server-Sent Events. Each event is
+// sent as a JSON-encoded Event, with a monotonically increasing sequence number as its ID.
+//
+// Clients can filter the events they receive with query parameters: "prefix" only sends events whose paths begin with
+// one of the given prefixes, and "type" only sends events of the given comma-separated types, e.g.
+// "/events?prefix=uploads/&type=added".
+type SSEHandler struct {
+	cfg SSEConfig
+
+	mu      sync.Mutex
+	seq     uint64
+	history []sseEvent
+	clients map[*sseClient]struct{}
+}
+
+// sseEvent is an event along with its sequence number.
+type sseEvent struct {
+	id    uint64
+	event Event
+}
+
+// sseClient is a connected client, and the events waiting to be sent to it.
+type sseClient struct {
+	filter sseFilter
+	events chan sseEvent
+}
+
+// sseFilter selects the events sent to a client.
+type sseFilter struct {
+	prefixes []string
+	mask     EventType
+}
+
+func (f sseFilter) match(event Event) bool {
+	if event.Type&f.mask == 0 {
+		return false
+	}
+	if len(f.prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range f.prefixes {
+		if strings.HasPrefix(event.File, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewSSEHandler creates an SSE handler. Events are streamed to clients once Run is called.
+func NewSSEHandler(cfg SSEConfig) *SSEHandler {
+	if cfg.HistorySize <= 0 {
+		cfg.HistorySize = DefaultSSEHistorySize
+	}
+	if cfg.ClientBuffer <= 0 {
+		cfg.ClientBuffer = DefaultSSEClientBuffer
+	}
+	if cfg.KeepAlive <= 0 {
+		cfg.KeepAlive = DefaultSSEKeepAlive
+	}
+	return &SSEHandler{
+		cfg:     cfg,
+		clients: make(map[*sseClient]struct{}),
+	}
+}
+
+// Run reads events from the channel and sends them to the connected clients until the channel is closed or the context
+// is cancelled.
+func (h *SSEHandler) Run(ctx context.Context, chanEvents <-chan Event) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-chanEvents:
+			if !ok {
+				return nil // Channel closed
+			}
+			h.publish(event)
+		}
+	}
+}
+
+// publish assigns the next sequence number to an event, adds it to the history and queues it for each client.
+func (h *SSEHandler) publish(event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	e := sseEvent{id: h.seq, event: event}
+	h.history = append(h.history, e)
+	if len(h.history) > h.cfg.HistorySize {
+		h.history = h.history[len(h.history)-h.cfg.HistorySize:]
+	}
+
+	for client := range h.clients {
+		if !client.filter.match(event) {
+			continue
+		}
+		select {
+		case client.events <- e:
+		default:
+			// The client has fallen too far behind, so disconnect it and let it resume from the history
+			delete(h.clients, client)
+			close(client.events)
+		}
+	}
+}
+
+// subscribe registers a client, and returns the events from the history that it missed since lastID. It also returns
+// true if some of the events it missed are no longer in the history, or lastID wasn't sent by this handler, so the
+// client can't resume without resyncing.
+func (h *SSEHandler) subscribe(client *sseClient, lastID uint64, resume bool) ([]sseEvent, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[client] = struct{}{}
+
+	if !resume {
+		return nil, false
+	}
+	var missed []sseEvent
+	for _, e := range h.history {
+		if e.id > lastID && client.filter.match(e.event) {
+			missed = append(missed, e)
+		}
+	}
+	reset := lastID > h.seq || (len(h.history) > 0 && lastID+1 < h.history[0].id)
+	return missed, reset
+}
+
+func (h *SSEHandler) unsubscribe(client *sseClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[client]; ok {
+		delete(h.clients, client)
+		close(client.events)
+	}
+}
+
+// ServeHTTP streams events to the client until it disconnects. If the request has a Last-Event-ID header, any events
+// after that ID that are still in the history are sent first. If some of them are no longer in the history, they are
+// preceded by an SSEResetEvent message.
+func (h *SSEHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// Parse the client's filters and resume position
+	query := req.URL.Query()
+	filter := sseFilter{prefixes: query["prefix"], mask: AllEvents}
+	if types := query.Get("type"); types != "" {
+		filter.mask = 0
+		for _, name := range strings.Split(types, ",") {
+			var eventType EventType
+			if err := eventType.UnmarshalText([]byte(strings.TrimSpace(name))); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			filter.mask |= eventType
+		}
+	}
+	var lastID uint64
+	lastEventID := req.Header.Get("Last-Event-ID")
+	if lastEventID != "" {
+		id, err := strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		lastID = id
+	}
+
+	client := &sseClient{filter: filter, events: make(chan sseEvent, h.cfg.ClientBuffer)}
+	missed, reset := h.subscribe(client, lastID, lastEventID != "")
+	defer h.unsubscribe(client)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if reset {
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: {}\n\n", SSEResetEvent); err != nil {
+			return
+		}
+	}
+	for _, e := range missed {
+		if err := writeSSEEvent(w, e); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(h.cfg.KeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case e, ok := <-client.events:
+			if !ok {
+				return // Disconnected for falling behind
+			}
+			if err := writeSSEEvent(w, e); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeSSEEvent writes a single event in the text/event-stream format.
+func writeSSEEvent(w http.ResponseWriter, e sseEvent) error {
+	data, err := json.Marshal(e.event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", e.id, data)
+	return err
+}
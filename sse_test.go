@@ -0,0 +1,172 @@
+package watchdir_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spiretechnology/go-watchdir/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// sseStream reads events from a Server-Sent Events response.
+type sseStream struct {
+	resp    *http.Response
+	scanner *bufio.Scanner
+}
+
+func openSSEStream(t *testing.T, url, lastEventID string) *sseStream {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err, "error creating request")
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err, "error connecting")
+	require.Equal(t, http.StatusOK, resp.StatusCode, "wrong status")
+	return &sseStream{resp: resp, scanner: bufio.NewScanner(resp.Body)}
+}
+
+func (s *sseStream) close() {
+	s.resp.Body.Close()
+}
+
+// message returns the event name, ID and data of the next message in the stream, skipping comments.
+func (s *sseStream) message(t *testing.T) (string, string, string) {
+	var name, id, data string
+	for s.scanner.Scan() {
+		line := s.scanner.Text()
+		switch {
+		case line == "":
+			if data != "" {
+				return name, id, data
+			}
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	t.Fatalf("stream ended: %v", s.scanner.Err())
+	return "", "", ""
+}
+
+// next returns the ID and event of the next message in the stream, which must be an event.
+func (s *sseStream) next(t *testing.T) (string, watchdir.Event) {
+	name, id, data := s.message(t)
+	require.Empty(t, name, "expected an event, got a %q message", name)
+	var event watchdir.Event
+	require.NoError(t, json.Unmarshal([]byte(data), &event), "error decoding event")
+	return id, event
+}
+
+func TestSSEHandler(t *testing.T) {
+	t.Run("streams filtered events and resumes from the history", func(t *testing.T) {
+		handler := watchdir.NewSSEHandler(watchdir.SSEConfig{HistorySize: 3})
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		chanEvents := make(chan watchdir.Event)
+		go func() {
+			_ = handler.Run(ctx, chanEvents)
+		}()
+		for _, event := range []watchdir.Event{
+			{Type: watchdir.FileAdded, File: "a/1"},
+			{Type: watchdir.FileAdded, File: "b/2"},
+			{Type: watchdir.FileRemoved, File: "a/3"},
+			{Type: watchdir.FileAdded, File: "a/4"},
+			{Type: watchdir.FileAdded, File: "a/5"},
+		} {
+			chanEvents <- event
+		}
+
+		// Resuming replays the matching events still in the history, then streams new ones
+		stream := openSSEStream(t, server.URL+"?prefix=a/&type=added", "2")
+		defer stream.close()
+		id, event := stream.next(t)
+		require.Equal(t, "4", id, "wrong event id")
+		require.Equal(t, watchdir.Event{Type: watchdir.FileAdded, File: "a/4"}, event, "wrong event")
+		id, _ = stream.next(t)
+		require.Equal(t, "5", id, "wrong event id")
+
+		chanEvents <- watchdir.Event{Type: watchdir.FileAdded, File: "b/6"}
+		chanEvents <- watchdir.Event{Type: watchdir.FileAdded, File: "a/7"}
+		id, event = stream.next(t)
+		require.Equal(t, "7", id, "wrong event id")
+		require.Equal(t, "a/7", event.File, "wrong event")
+	})
+	t.Run("tells clients to resync when the events they missed overflowed the history", func(t *testing.T) {
+		handler := watchdir.NewSSEHandler(watchdir.SSEConfig{HistorySize: 2})
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		chanEvents := make(chan watchdir.Event)
+		go func() {
+			_ = handler.Run(ctx, chanEvents)
+		}()
+		for _, file := range []string{"1", "2", "3", "4"} {
+			chanEvents <- watchdir.Event{Type: watchdir.FileAdded, File: file}
+		}
+
+		// Events 2 and 3 were missed but only 3 is still in the history, so a reset comes first
+		stream := openSSEStream(t, server.URL, "1")
+		defer stream.close()
+		name, _, _ := stream.message(t)
+		require.Equal(t, watchdir.SSEResetEvent, name, "should tell the client to resync")
+		id, _ := stream.next(t)
+		require.Equal(t, "3", id, "wrong event id")
+		id, _ = stream.next(t)
+		require.Equal(t, "4", id, "wrong event id")
+
+		// Resuming from an ID that is still covered by the history doesn't reset
+		resumed := openSSEStream(t, server.URL, "2")
+		defer resumed.close()
+		id, _ = resumed.next(t)
+		require.Equal(t, "3", id, "wrong event id")
+
+		// An ID the handler never sent, such as one from before a restart, also resets
+		restarted := openSSEStream(t, server.URL, "10")
+		defer restarted.close()
+		name, _, _ = restarted.message(t)
+		require.Equal(t, watchdir.SSEResetEvent, name, "should tell the client to resync")
+	})
+	t.Run("new clients only receive new events", func(t *testing.T) {
+		handler := watchdir.NewSSEHandler(watchdir.SSEConfig{})
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		chanEvents := make(chan watchdir.Event)
+		go func() {
+			_ = handler.Run(ctx, chanEvents)
+		}()
+		chanEvents <- watchdir.Event{Type: watchdir.FileAdded, File: "old"}
+
+		stream := openSSEStream(t, server.URL, "")
+		defer stream.close()
+		chanEvents <- watchdir.Event{Type: watchdir.FileRemoved, File: "new"}
+		id, event := stream.next(t)
+		require.Equal(t, "2", id, "wrong event id")
+		require.Equal(t, watchdir.Event{Type: watchdir.FileRemoved, File: "new"}, event, "wrong event")
+	})
+	t.Run("rejects unknown event types", func(t *testing.T) {
+		server := httptest.NewServer(watchdir.NewSSEHandler(watchdir.SSEConfig{KeepAlive: time.Second}))
+		defer server.Close()
+		resp, err := http.Get(server.URL + "?type=renamed")
+		require.NoError(t, err, "error connecting")
+		resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode, "wrong status")
+	})
+}
@@ -3,6 +3,7 @@ package watchdir
 import (
 	"context"
 	"errors"
+	"fmt"
 	"time"
 )
 
@@ -19,19 +20,125 @@ type Watcher interface {
 	Sweep(ctx context.Context, chanEvents chan<- Event) error
 }
 
+// DirWatcher is a Watcher that also exposes its view of the directory, which is implemented by the watchers returned by
+// New and NewDirWatcher. It can only be implemented by this package, so methods may be added to it without breaking
+// callers. Wrappers, such as mocks, can embed a DirWatcher and override only the methods they need.
+type DirWatcher interface {
+	Watcher
+
+	// Plan finds the changes a sweep would report, without applying them to the watcher's view of the directory. The
+	// changes are applied by committing the returned change set, or abandoned by discarding it, which allows dry runs
+	// and applying only the changes that were handled successfully. Pending calls to Forget take effect when planning.
+	Plan(ctx context.Context) (*ChangeSet, error)
+
+	// Baseline performs a sweep that updates the watcher's view of the directory without sending any events, so that
+	// only changes made after the baseline are reported by future sweeps. It blocks until any in-progress sweep completes.
+	Baseline(ctx context.Context) error
+
+	// Forget removes a file or directory from the watcher's view, so that the next sweep reports its files as added
+	// again. The path is in the same form as event paths. It is safe to call at any time, and takes effect before the
+	// next sweep or rescan begins.
+	Forget(path string)
+
+	// Rescan immediately sweeps a single directory and its subdirectories, sending events for any changes within it. The
+	// path is in the same form as event paths. If it refers to a file, the directory containing it is rescanned, and if
+	// it no longer exists, its nearest existing ancestor is rescanned so that the removal is reported. It blocks until
+	// any in-progress sweep completes.
+	Rescan(ctx context.Context, path string, chanEvents chan<- Event) error
+
+	// Snapshot returns the files known to the watcher as of the most recent sweep.
+	Snapshot(ctx context.Context) (Snapshot, error)
+
+	// Len returns the number of files known to the watcher. It only counts the watcher's view of the directory, without
+	// filtering or inspecting the files, so it is cheap enough to call after every sweep.
+	Len() int
+
+	// dirWatcher prevents implementations outside this package.
+	dirWatcher()
+}
+
 // EventType defines an operation that took place on the watch directory
 type EventType uint8
 
 const (
 	FileAdded   = EventType(1 << 0)
 	FileRemoved = EventType(1 << 1)
-	AllEvents   = 0b11111111
+
+	// FileModified is only reported by Diff, for files whose size, modification time or mode differ between snapshots.
+	FileModified = EventType(1 << 2)
+
+	// Overflow is only sent by EventBuffer, after it has dropped events. Its File is the deepest directory containing
+	// every dropped event, which can be passed to Forget and then Rescan to report the files within it again.
+	Overflow = EventType(1 << 3)
+
+	// FileTimeout is sent once for a file that has been held back for longer than its timeout, such as one whose
+	// completion marker never appears, or for a bundle that is still missing members. The file or bundle is still
+	// reported if it becomes ready later.
+	FileTimeout = EventType(1 << 4)
+
+	// BundleReady is sent once every member of a bundle is ready, instead of a FileAdded event for each member. The
+	// event's file is the bundle's directory and stem, and its members are listed in the event.
+	BundleReady = EventType(1 << 5)
+
+	AllEvents = 0b11111111
 )
 
+// String returns the name of the event type.
+func (t EventType) String() string {
+	switch t {
+	case FileAdded:
+		return "added"
+	case FileRemoved:
+		return "removed"
+	case FileModified:
+		return "modified"
+	case Overflow:
+		return "overflow"
+	case FileTimeout:
+		return "timeout"
+	case BundleReady:
+		return "bundle"
+	default:
+		return fmt.Sprintf("EventType(%d)", uint8(t))
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (t EventType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (t *EventType) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case FileAdded.String():
+		*t = FileAdded
+	case FileRemoved.String():
+		*t = FileRemoved
+	case FileModified.String():
+		*t = FileModified
+	case Overflow.String():
+		*t = Overflow
+	case FileTimeout.String():
+		*t = FileTimeout
+	case BundleReady.String():
+		*t = BundleReady
+	default:
+		return fmt.Errorf("unknown event type %q", text)
+	}
+	return nil
+}
+
 // Event represents a file event
 type Event struct {
-	Type EventType
-	File string
+	Type EventType `json:"type"`
+	File string    `json:"file"`
+
+	// Sweep is the sequence number of the sweep that produced the event, starting from 1 for the watcher's first sweep.
+	Sweep uint64 `json:"sweep,omitempty"`
+
+	// Members lists the files in a bundle, for BundleReady events and the FileTimeout events of incomplete bundles.
+	Members []string `json:"members,omitempty"`
 }
 
 // Watch performs a periodic sweep of a given directory and sends events to the provided channel.
@@ -58,3 +165,67 @@ func Watch(
 		}
 	}
 }
+
+// WatchWithScheduler performs repeated sweeps of a given directory and sends events to the provided channel. The time
+// to wait between sweeps is chosen by the scheduler after each sweep.
+//
+// The events sent by watchers created by New are counted as they are sent, and are always delivered once the watcher
+// has committed them, so chanEvents must be read until WatchWithScheduler returns. Other watchers' events are counted
+// by forwarding them, and those still being forwarded when the context is cancelled may be dropped.
+func WatchWithScheduler(
+	ctx context.Context,
+	w Watcher,
+	scheduler Scheduler,
+	chanEvents chan<- Event,
+) error {
+	for {
+		// Perform the sweep iteration
+		count, err := sweepCounting(ctx, w, chanEvents)
+		if err != nil {
+			// If the context was cancelled, return that error
+			if errors.Is(err, context.Canceled) {
+				return err
+			}
+		}
+
+		// Sleep for the interval chosen by the scheduler
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(scheduler.Next(count)):
+		}
+	}
+}
+
+// countingSweeper is implemented by the watchers created by New, which count the events each sweep sends.
+type countingSweeper interface {
+	sweepCounting(ctx context.Context, chanEvents chan<- Event) (int, error)
+}
+
+// sweepCounting performs a single sweep, sending its events to the provided channel, and returns the number of events
+// that were sent.
+func sweepCounting(ctx context.Context, w Watcher, chanEvents chan<- Event) (int, error) {
+	if w, ok := w.(countingSweeper); ok {
+		return w.sweepCounting(ctx, chanEvents)
+	}
+
+	// The watcher considers each event sent once the forwarder has received it. Once the context is cancelled the
+	// consumer may have stopped reading, so the remaining events are drained instead, letting the sweep finish.
+	var count int
+	chanSweep := make(chan Event)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range chanSweep {
+			select {
+			case chanEvents <- event:
+				count++
+			case <-ctx.Done():
+			}
+		}
+	}()
+	err := w.Sweep(ctx, chanSweep)
+	close(chanSweep)
+	<-done
+	return count, err
+}
@@ -6,14 +6,22 @@ import (
 	"fmt"
 	"io/fs"
 	"log"
+	"maps"
 	"os"
 	"path"
+	"sync"
 	"time"
 
 	"golang.org/x/sync/errgroup"
 )
 
 func New(fsys fs.FS, options ...Option) Watcher {
+	return NewDirWatcher(fsys, options...)
+}
+
+// NewDirWatcher creates a watcher in the same way as New, returning it as a DirWatcher so that its view of the
+// directory can be used.
+func NewDirWatcher(fsys fs.FS, options ...Option) DirWatcher {
 	wd := &watcher{
 		fsys:                    fsys,
 		eventsMask:              AllEvents,
@@ -27,12 +35,43 @@ func New(fsys fs.FS, options ...Option) Watcher {
 	for _, option := range options {
 		option(wd)
 	}
+	if wd.openFiles != nil {
+		wd.openFiles.logger = wd.logger
+	}
+	if wd.initialSnapshot != nil {
+		// The restored files are already known, so there's no need for a silent baseline
+		wd.restore(wd.initialSnapshot.entries)
+		wd.initialSnapshot = nil
+		wd.baselined = true
+	}
 	return wd
 }
 
+// dirCache holds the entries of a directory as of the last sweep. Sweeps don't modify the cache until their changes are
+// committed, and commits hold the lock while writing so that readers such as Snapshot can walk the cache concurrently.
 type dirCache struct {
+	mu       sync.RWMutex
 	entries  map[string]fs.DirEntry
 	children map[string]*dirCache
+
+	// pending holds the files that have been found but aren't ready to be reported yet. It is only used by sweeps, and
+	// is replaced along with the entries when changes are committed.
+	pending map[string]pendingFile
+}
+
+// pendingFile tracks a file that is being held back until it is ready. The size and modification time are the last
+// ones observed, and observations counts the consecutive sweeps that have seen them unchanged.
+type pendingFile struct {
+	since        time.Time
+	timedOut     bool
+	size         int64
+	modTime      time.Time
+	observations int
+}
+
+// excludedEntry marks a cached file that didn't pass the file filter, so that it isn't counted as known.
+type excludedEntry struct {
+	fs.DirEntry
 }
 
 func newDirCache() *dirCache {
@@ -50,9 +89,29 @@ type watcher struct {
 	dirFilter               Filter
 	maxDepth                uint
 	writeStabilityThreshold time.Duration
+	stableObservations      int
 	logger                  *log.Logger
+	silentBaseline          bool
+	silentNewSubtrees       bool
+	initialSnapshot         *Snapshot
+	lease                   *Lease
+	leading                 bool
+	partition               *Partition
+	markers                 *MarkerConfig
+	bundles                 []BundleRule
+	openFiles               *openFileCheck
+
+	// sweepMu serializes all operations that plan or commit changes to the cache. The generation is incremented each
+	// time the cache is modified, so change sets planned against an older cache can't be committed.
+	sweepMu    sync.Mutex
+	cache      *dirCache
+	baselined  bool
+	sweepID    uint64
+	generation uint64
 
-	cache *dirCache
+	// forgotten holds the paths passed to Forget, which are removed from the cache before the next sweep
+	forgetMu  sync.Mutex
+	forgotten []string
 }
 
 func (wd *watcher) getSweepFS() (fs.FS, error) {
@@ -75,7 +134,36 @@ func (wd *watcher) getSweepFS() (fs.FS, error) {
 	return fs.Sub(wd.fsys, wd.subRoot)
 }
 
-func (wd *watcher) Sweep(ctx context.Context, chanEvents chan<- Event) (reterr error) {
+func (wd *watcher) Sweep(ctx context.Context, chanEvents chan<- Event) error {
+	_, err := wd.sweepCounting(ctx, chanEvents)
+	return err
+}
+
+// sweepCounting performs a sweep, and returns the number of events it sent.
+func (wd *watcher) sweepCounting(ctx context.Context, chanEvents chan<- Event) (int, error) {
+	wd.sweepMu.Lock()
+	defer wd.sweepMu.Unlock()
+
+	// Only the leader sweeps, and the initial sweep only populates the cache if a silent baseline is configured
+	if standby, err := wd.standby(); standby || err != nil {
+		return 0, err
+	}
+	return wd.sweepRoot(ctx, chanEvents, wd.silentBaseline && !wd.baselined)
+}
+
+func (wd *watcher) dirWatcher() {}
+
+func (wd *watcher) Baseline(ctx context.Context) error {
+	wd.sweepMu.Lock()
+	defer wd.sweepMu.Unlock()
+	if standby, err := wd.standby(); standby || err != nil {
+		return err
+	}
+	_, err := wd.sweepRoot(ctx, nil, true)
+	return err
+}
+
+func (wd *watcher) sweepRoot(ctx context.Context, chanEvents chan<- Event, silent bool) (sent int, reterr error) {
 	startTime := time.Now()
 	wd.logger.Println("sweep started")
 	defer func() {
@@ -87,14 +175,64 @@ func (wd *watcher) Sweep(ctx context.Context, chanEvents chan<- Event) (reterr e
 		}
 	}()
 
+	cs, err := wd.planRoot(ctx, silent)
+	if err != nil {
+		return 0, err
+	}
+	return wd.apply(ctx, cs, chanEvents)
+}
+
+// planRoot computes the changes to the whole file system since the last sweep. It must be called while holding the
+// sweep lock.
+func (wd *watcher) planRoot(ctx context.Context, silent bool) (*ChangeSet, error) {
 	// Get the fsys for the sweep, which can be a sub-fs
 	fsys, err := wd.getSweepFS()
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// Remove any forgotten paths from the cache, so they are reported again, and hand over partitions that have moved
+	wd.applyForgotten()
+	if err := wd.applyPartition(ctx); err != nil {
+		return nil, err
 	}
+	wd.sweepID++
 
 	// Sweep the file system recursively
-	return wd.sweep(ctx, fsys, chanEvents, 0, ".", wd.cache)
+	cs := wd.newChangeSet(true)
+	if err := wd.plan(ctx, fsys, cs, 0, ".", wd.cache, silent); err != nil {
+		return nil, err
+	}
+	cs.sort()
+	return cs, nil
+}
+
+// apply sends the events in a change set, then commits it, and returns the number of events sent. If sending is
+// interrupted, only the changes whose events were sent are committed, so the rest are found again by the next sweep. It
+// must be called while holding the sweep lock.
+func (wd *watcher) apply(ctx context.Context, cs *ChangeSet, chanEvents chan<- Event) (int, error) {
+	var sent int
+	for i, change := range cs.changes {
+		if !change.reported {
+			continue
+		}
+		if err := wd.emit(ctx, chanEvents, change.event); err != nil {
+			cs.commitChanges(cs.changes[:i])
+			return sent, err
+		}
+		sent++
+	}
+	cs.commit()
+	return sent, nil
+}
+
+// captureEntry returns a directory entry that holds the file's current metadata, rather than reading it on demand.
+func captureEntry(entry fs.DirEntry) (fs.DirEntry, error) {
+	info, err := entry.Info()
+	if err != nil {
+		return nil, err
+	}
+	return fs.FileInfoToDirEntry(info), nil
 }
 
 func readDir(fsys fs.FS, pathPrefix string) (map[string]fs.DirEntry, error) {
@@ -112,7 +250,9 @@ func readDir(fsys fs.FS, pathPrefix string) (map[string]fs.DirEntry, error) {
 	return entriesMap, nil
 }
 
-func (wd *watcher) sweep(ctx context.Context, fsys fs.FS, chanEvents chan<- Event, depth uint, pathPrefix string, cache *dirCache) error {
+// plan compares a directory and its subdirectories with the cache, and records the changes in the change set without
+// modifying the cache.
+func (wd *watcher) plan(ctx context.Context, fsys fs.FS, cs *ChangeSet, depth uint, pathPrefix string, cache *dirCache, silent bool) error {
 	// Breakout if the context is cancelled.
 	if err := ctx.Err(); err != nil {
 		return err
@@ -129,6 +269,11 @@ func (wd *watcher) sweep(ctx context.Context, fsys fs.FS, chanEvents chan<- Even
 		}
 	}
 
+	// If this directory belongs to another partition, skip it
+	if wd.partition != nil && !wd.partition.sweepsDir(pathPrefix) {
+		return nil
+	}
+
 	// Return if the depth is too deep
 	if depth >= wd.maxDepth {
 		wd.logger.Printf("hit max depth %d", depth)
@@ -141,13 +286,29 @@ func (wd *watcher) sweep(ctx context.Context, fsys fs.FS, chanEvents chan<- Even
 		return fmt.Errorf("read dir %q: %w", pathPrefix, err)
 	}
 
+	// Markers are looked up in the full listing, before any unready files are removed from it. Directory markers in
+	// ancestors are only looked up once, the first time a file needs them.
+	listing := entries
+	var markedAbove func() bool
+	if wd.markers != nil {
+		listing = maps.Clone(entries)
+		markedAbove = sync.OnceValue(func() bool {
+			return wd.markers.markedAbove(fsys, pathPrefix)
+		})
+	}
+
 	// Find entries that are newly added (didn't previously exist)
+	pending := make(map[string]pendingFile)
+	bundles := make(map[bundleKey]*bundle)
 	for name, entry := range entries {
 		if entry.IsDir() {
 			continue
 		}
-		// If the file already exists in the cache, skip it
-		if cache.entries[name] != nil {
+		// If the file already exists in the cache, skip it, keeping the metadata recorded when it was first found
+		if cached := cache.entries[name]; cached != nil {
+			if !cached.IsDir() {
+				entries[name] = cached
+			}
 			continue
 		}
 		// Ignore the file if it doesn't pass the file filter
@@ -157,82 +318,110 @@ func (wd *watcher) sweep(ctx context.Context, fsys fs.FS, chanEvents chan<- Even
 				return fmt.Errorf("filter file %q: %w", name, err)
 			}
 			if !include {
+				entries[name] = excludedEntry{entry}
 				continue
 			}
 		}
-		// Ignore the file if it fails the write stability threshold
-		if wd.writeStabilityThreshold > 0 {
-			stat, err := entry.Info()
-			if err != nil {
-				return fmt.Errorf("stat entry %q: %w", name, err)
+		// Forget the file if it belongs to another partition, so it is found if the partition moves here
+		if wd.partition != nil && !wd.partition.sweepsFile(path.Join(pathPrefix, name)) {
+			delete(entries, name)
+			continue
+		}
+		// Capture the file's metadata as of this sweep, which is kept in the cache and written to snapshots
+		entry, err := captureEntry(entry)
+		if errors.Is(err, fs.ErrNotExist) {
+			delete(entries, name)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("stat entry %q: %w", name, err)
+		}
+		entries[name] = entry
+		// Hold the file back until its completion marker appears. Hidden markers are recorded without being reported.
+		if wd.markers != nil {
+			if wd.markers.hides(name) {
+				continue
 			}
-			if stat.ModTime().Add(wd.writeStabilityThreshold).After(time.Now()) {
+			if !wd.markers.ready(name, listing, markedAbove) {
 				delete(entries, name)
+				if timedOut, ok := wd.holdBack(cache, pending, path.Join(pathPrefix, name), wd.markers.Timeout, silent); ok {
+					cs.add(timedOut)
+				}
 				continue
 			}
 		}
-		// If the file is new, send an event
-		if wd.eventsMask&FileAdded != 0 {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			case chanEvents <- Event{
-				Type: FileAdded,
-				File: wd.prependSubRoot(path.Join(pathPrefix, name)),
-			}:
+		// Ignore the file until it is stable. Silent sweeps record it regardless, since no event is sent for it.
+		stable, err := wd.stable(cache, pending, name, entry)
+		if err != nil {
+			return err
+		}
+		if !stable && !silent {
+			delete(entries, name)
+			continue
+		}
+		// Hold the file back until every member of its bundle is ready
+		if len(wd.bundles) > 0 {
+			if rule, stem := wd.bundleRule(name); rule != nil {
+				delete(entries, name)
+				addMember(bundles, rule, stem, entry)
+				continue
 			}
 		}
+		// Hold the file back while a process still has it open for writing. This is checked last, since listing the open
+		// files is expensive, and silent sweeps record the file regardless.
+		if !silent && wd.isOpen(path.Join(pathPrefix, name)) {
+			delete(entries, name)
+			continue
+		}
+		// The file is new
+		cs.add(wd.newChange(FileAdded, path.Join(pathPrefix, name), entry, silent))
 	}
+	wd.planBundles(cs, cache, bundles, entries, pending, pathPrefix, silent)
 
 	// Find entries that were removed (existed previously but not now)
+	update := dirUpdate{cache: cache, dir: pathPrefix, entries: entries, pending: pending}
 	for name, prevEntry := range cache.entries {
 		if _, stillExists := entries[name]; stillExists {
 			continue
 		}
 		if prevEntry.IsDir() {
-			if err := wd.sweepDeleted(ctx, fsys, chanEvents, path.Join(pathPrefix, name), cache.children[name]); err != nil {
-				return fmt.Errorf("sweep deleted directory %q: %w", path.Join(pathPrefix, name), err)
-			}
-			delete(cache.children, name)
-		} else if wd.eventsMask&FileRemoved != 0 {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			case chanEvents <- Event{
-				Type: FileRemoved,
-				File: wd.prependSubRoot(path.Join(pathPrefix, name)),
-			}:
-			}
+			wd.planDeleted(cs, path.Join(pathPrefix, name), cache.children[name], silent)
+			update.removed = append(update.removed, name)
+		} else if wd.markers == nil || !wd.markers.hides(name) {
+			cs.add(wd.newChange(FileRemoved, path.Join(pathPrefix, name), nil, silent))
 		}
 	}
 
-	// Update the cache with the current entries
-	cache.entries = entries
-
-	var eg errgroup.Group
-
-	// Quickly update the children map to ensure it has entries for all current directories
-	// This cannot be done concurrently due to map access
+	// Create caches for directories that haven't been seen before. They aren't attached to the tree until the change set
+	// is committed.
+	children := make(map[string]*dirCache)
 	for name, entry := range entries {
 		if entry.IsDir() {
-			// Create the child cache if it doesn't exist
-			if cache.children[name] == nil {
-				cache.children[name] = newDirCache()
+			children[name] = cache.children[name]
+			if children[name] == nil {
+				children[name] = newDirCache()
+				if update.added == nil {
+					update.added = make(map[string]*dirCache)
+				}
+				update.added[name] = children[name]
 			}
 		}
 	}
+	cs.addUpdate(update)
+
+	var eg errgroup.Group
 
 	// Sweep all child directories
-	for name, entry := range entries {
-		if entry.IsDir() {
-			eg.Go(func() error {
-				// Recursively sweep the child directory, creating the new cache for it
-				if err := wd.sweep(ctx, fsys, chanEvents, depth+1, path.Join(pathPrefix, name), cache.children[name]); err != nil {
-					return fmt.Errorf("sweep directory %q: %w", path.Join(pathPrefix, name), err)
-				}
-				return nil
-			})
-		}
+	for name, child := range children {
+		// Directories that appeared since the initial sweep can be populated without sending events
+		childSilent := silent || (wd.silentNewSubtrees && wd.baselined && update.added[name] != nil)
+		eg.Go(func() error {
+			// Recursively sweep the child directory
+			if err := wd.plan(ctx, fsys, cs, depth+1, path.Join(pathPrefix, name), child, childSilent); err != nil {
+				return fmt.Errorf("sweep directory %q: %w", path.Join(pathPrefix, name), err)
+			}
+			return nil
+		})
 	}
 
 	// Wait for all of the goroutines to complete
@@ -242,30 +431,94 @@ func (wd *watcher) sweep(ctx context.Context, fsys fs.FS, chanEvents chan<- Even
 	return nil
 }
 
-func (wd *watcher) sweepDeleted(ctx context.Context, fsys fs.FS, chanEvents chan<- Event, pathPrefix string, cache *dirCache) error {
+// planDeleted records the removal of every file within a directory that no longer exists.
+func (wd *watcher) planDeleted(cs *ChangeSet, pathPrefix string, cache *dirCache, silent bool) {
 	// Get the previous sweep data for this directory
 	if cache == nil {
-		return nil // Nothing to sweep
+		return // Nothing to sweep
 	}
 
 	// Loop over all of the entries that were previously cached
 	for name, prevEntry := range cache.entries {
 		if prevEntry.IsDir() {
-			if err := wd.sweepDeleted(ctx, fsys, chanEvents, path.Join(pathPrefix, name), cache.children[name]); err != nil {
-				return fmt.Errorf("sweep deleted directory %q: %w", path.Join(pathPrefix, name), err)
-			}
-		} else if wd.eventsMask&FileRemoved != 0 {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			case chanEvents <- Event{
-				Type: FileRemoved,
-				File: wd.prependSubRoot(path.Join(pathPrefix, name)),
-			}:
-			}
+			wd.planDeleted(cs, path.Join(pathPrefix, name), cache.children[name], silent)
+		} else if wd.markers == nil || !wd.markers.hides(name) {
+			cs.add(wd.newChange(FileRemoved, path.Join(pathPrefix, name), nil, silent))
 		}
 	}
-	return nil
+}
+
+// stable returns true if a file has stopped being written to. With stable observations configured, the file's size and
+// modification time must be unchanged for that many consecutive sweeps, which are counted in the pending files whether
+// or not the file is reported, so the count carries on if the file is held back for another reason. Otherwise, the file
+// must not have been modified within the write stability threshold.
+func (wd *watcher) stable(cache *dirCache, pending map[string]pendingFile, name string, entry fs.DirEntry) (bool, error) {
+	if wd.stableObservations <= 0 && wd.writeStabilityThreshold <= 0 {
+		return true, nil
+	}
+	stat, err := entry.Info()
+	if err != nil {
+		return false, fmt.Errorf("stat entry %q: %w", name, err)
+	}
+	if wd.stableObservations <= 0 {
+		return !stat.ModTime().Add(wd.writeStabilityThreshold).After(time.Now()), nil
+	}
+
+	held, ok := cache.pending[name]
+	if !ok {
+		held.since = time.Now()
+	}
+	if held.observations > 0 && held.size == stat.Size() && held.modTime.Equal(stat.ModTime()) {
+		held.observations++
+	} else {
+		held.size, held.modTime, held.observations = stat.Size(), stat.ModTime(), 1
+	}
+	pending[name] = held
+	return held.observations >= wd.stableObservations, nil
+}
+
+// holdBack records that a file isn't ready to be reported yet. Once it has been held back for longer than the timeout,
+// it returns the timeout change to report, which is only returned once.
+func (wd *watcher) holdBack(cache *dirCache, pending map[string]pendingFile, rel string, timeout time.Duration, silent bool) (change, bool) {
+	name := path.Base(rel)
+	held, ok := cache.pending[name]
+	if !ok {
+		held.since = time.Now()
+	}
+	timedOut := timeout > 0 && !held.timedOut && time.Since(held.since) >= timeout
+	if timedOut {
+		held.timedOut = true
+	}
+	pending[name] = held
+	if !timedOut {
+		return change{}, false
+	}
+	return wd.newChange(FileTimeout, rel, nil, silent), true
+}
+
+// newChange creates the change for a file, which is reported unless the sweep is silent or its type is excluded by the
+// events mask.
+func (wd *watcher) newChange(eventType EventType, rel string, entry fs.DirEntry, silent bool) change {
+	return change{
+		event: Event{
+			Type:  eventType,
+			File:  wd.prependSubRoot(rel),
+			Sweep: wd.sweepID,
+		},
+		rel:      rel,
+		entry:    entry,
+		reported: !silent && wd.eventsMask&eventType != 0,
+	}
+}
+
+// emit sends an event to the channel.
+func (wd *watcher) emit(ctx context.Context, chanEvents chan<- Event, event Event) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case chanEvents <- event:
+		return nil
+	}
 }
 
 func (wd *watcher) prependSubRoot(name string) string {
@@ -2,7 +2,10 @@ package watchdir_test
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/spiretechnology/go-memfs"
 	"github.com/spiretechnology/go-watchdir/v2"
@@ -267,4 +270,338 @@ func TestWatchDir(t *testing.T) {
 		mockDirFilter.AssertExpectations(t)
 		mockFileFilter.AssertExpectations(t)
 	})
+	t.Run("silent baseline", func(t *testing.T) {
+		fsys := memfs.FS{
+			"foo":       memfs.File("hello"),
+			"hello/bar": memfs.File("world"),
+		}
+		wd := watchdir.New(fsys,
+			watchdir.WithWriteStabilityThreshold(0),
+			watchdir.WithSilentBaseline(),
+		)
+
+		// Initial sweep. Should find nothing, since the files already existed.
+		events, err := sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.Len(t, events[watchdir.FileAdded], 0, "wrong number of add events")
+		require.Len(t, events[watchdir.FileRemoved], 0, "wrong number of remove events")
+
+		// Add and remove some files
+		fsys["hello/baz"] = memfs.File("golang")
+		delete(fsys, "foo")
+
+		// Second sweep. Should report the changes.
+		events, err = sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.ElementsMatch(t, []string{"hello/baz"}, events[watchdir.FileAdded], "wrong files added")
+		require.ElementsMatch(t, []string{"foo"}, events[watchdir.FileRemoved], "wrong files removed")
+	})
+	t.Run("silent new subtrees", func(t *testing.T) {
+		fsys := memfs.FS{
+			"foo": memfs.File("hello"),
+		}
+		wd := watchdir.New(fsys,
+			watchdir.WithWriteStabilityThreshold(0),
+			watchdir.WithSilentNewSubtrees(),
+		)
+
+		// Initial sweep. Should find the existing file.
+		events, err := sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.ElementsMatch(t, []string{"foo"}, events[watchdir.FileAdded], "wrong files added")
+
+		// Add a new directory full of files
+		fsys["hello/a"] = memfs.File("")
+		fsys["hello/b/c"] = memfs.File("")
+		fsys["bar"] = memfs.File("")
+
+		// Second sweep. Should only report the file outside the new directory.
+		events, err = sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.ElementsMatch(t, []string{"bar"}, events[watchdir.FileAdded], "wrong files added")
+
+		// Add a file to the directory that is now known
+		fsys["hello/d"] = memfs.File("")
+
+		// Third sweep. Should report the new file.
+		events, err = sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.ElementsMatch(t, []string{"hello/d"}, events[watchdir.FileAdded], "wrong files added")
+	})
+	t.Run("baseline on demand", func(t *testing.T) {
+		fsys := memfs.FS{
+			"foo": memfs.File("hello"),
+		}
+		wd := watchdir.NewDirWatcher(fsys, watchdir.WithWriteStabilityThreshold(0))
+
+		// Initial sweep. Should find the existing file.
+		events, err := sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.ElementsMatch(t, []string{"foo"}, events[watchdir.FileAdded], "wrong files added")
+
+		// Change the files, then re-baseline
+		fsys["bar"] = memfs.File("world")
+		delete(fsys, "foo")
+		require.NoError(t, wd.Baseline(context.Background()), "error baselining")
+
+		// Second sweep. Should find nothing new.
+		events, err = sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.Len(t, events[watchdir.FileAdded], 0, "wrong number of add events")
+		require.Len(t, events[watchdir.FileRemoved], 0, "wrong number of remove events")
+	})
+	t.Run("forget re-reports files on the next sweep", func(t *testing.T) {
+		fsys := memfs.FS{
+			"foo":       memfs.File("hello"),
+			"hello/bar": memfs.File("world"),
+			"hello/baz": memfs.File("golang"),
+			"world/a":   memfs.File(""),
+		}
+		wd := watchdir.NewDirWatcher(fsys,
+			watchdir.WithWriteStabilityThreshold(0),
+		)
+
+		// Initial sweep. Should find all the files.
+		events, err := sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.Len(t, events[watchdir.FileAdded], 4, "wrong number of add events")
+
+		// Forget a file and a directory
+		wd.Forget("foo")
+		wd.Forget("hello")
+
+		// Second sweep. Should report the forgotten files again.
+		events, err = sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.ElementsMatch(t, []string{"foo", "hello/bar", "hello/baz"}, events[watchdir.FileAdded], "wrong files added")
+		require.Len(t, events[watchdir.FileRemoved], 0, "wrong number of remove events")
+	})
+	t.Run("rescan a single subtree", func(t *testing.T) {
+		fsys := memfs.FS{
+			"hello/foo/a": memfs.File(""),
+			"hello/bar/a": memfs.File(""),
+			"world/a":     memfs.File(""),
+		}
+		wd := watchdir.NewDirWatcher(fsys,
+			watchdir.WithWriteStabilityThreshold(0),
+			watchdir.WithSubRoot("hello"),
+		)
+
+		// Initial sweep. Should find the files in the sub-root.
+		events, err := sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.Len(t, events[watchdir.FileAdded], 2, "wrong number of add events")
+
+		// Change files in both subdirectories, and forget one of them
+		fsys["hello/foo/b"] = memfs.File("")
+		fsys["hello/bar/b"] = memfs.File("")
+		wd.Forget("hello/foo/a")
+
+		// Rescan one subdirectory. Should only report changes within it.
+		chanEvents := make(chan watchdir.Event, 10)
+		require.NoError(t, wd.Rescan(context.Background(), "hello/foo", chanEvents), "error rescanning")
+		close(chanEvents)
+		var added []string
+		for event := range chanEvents {
+			require.Equal(t, watchdir.FileAdded, event.Type, "wrong event type")
+			added = append(added, event.File)
+		}
+		require.ElementsMatch(t, []string{"hello/foo/a", "hello/foo/b"}, added, "wrong files added")
+
+		// Full sweep. Should only report the remaining change.
+		events, err = sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.ElementsMatch(t, []string{"hello/bar/b"}, events[watchdir.FileAdded], "wrong files added")
+
+		// Rescanning outside of the sub-root is an error
+		require.Error(t, wd.Rescan(context.Background(), "world", nil), "should error rescanning outside sub-root")
+	})
+	t.Run("rescan records new directories", func(t *testing.T) {
+		fsys := memfs.FS{
+			"foo": memfs.File(""),
+		}
+		wd := watchdir.NewDirWatcher(fsys, watchdir.WithWriteStabilityThreshold(0))
+		_, err := sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+
+		// Rescan a directory that appeared since the sweep
+		fsys["new/a"] = memfs.File("")
+		chanEvents := make(chan watchdir.Event, 10)
+		require.NoError(t, wd.Rescan(context.Background(), "new", chanEvents), "error rescanning")
+		close(chanEvents)
+		require.Equal(t, watchdir.Event{Type: watchdir.FileAdded, File: "new/a", Sweep: 2}, <-chanEvents, "wrong event")
+		snap, err := wd.Snapshot(context.Background())
+		require.NoError(t, err, "error taking snapshot")
+		_, ok := snap.Lookup("new/a")
+		require.True(t, ok, "snapshot should include the rescanned file")
+
+		// Removing the directory reports its files as removed
+		delete(fsys, "new/a")
+		events, err := sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.Equal(t, map[watchdir.EventType][]string{watchdir.FileRemoved: {"new/a"}}, events, "wrong events")
+	})
+	t.Run("rescan reports a removed subtree", func(t *testing.T) {
+		fsys := memfs.FS{
+			"keep":       memfs.File(""),
+			"gone/a":     memfs.File(""),
+			"gone/sub/b": memfs.File(""),
+		}
+		wd := watchdir.NewDirWatcher(fsys, watchdir.WithWriteStabilityThreshold(0))
+		_, err := sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+
+		// Remove the subtree, then rescan a path within it. The nearest existing ancestor is rescanned instead.
+		delete(fsys, "gone/a")
+		delete(fsys, "gone/sub/b")
+		chanEvents := make(chan watchdir.Event, 10)
+		require.NoError(t, wd.Rescan(context.Background(), "gone/sub", chanEvents), "error rescanning")
+		close(chanEvents)
+		var removed []string
+		for event := range chanEvents {
+			require.Equal(t, watchdir.FileRemoved, event.Type, "wrong event type")
+			removed = append(removed, event.File)
+		}
+		require.ElementsMatch(t, []string{"gone/a", "gone/sub/b"}, removed, "wrong files removed")
+
+		// The removal is recorded, so a full sweep finds nothing new
+		events, err := sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.Empty(t, events, "wrong events")
+	})
+	t.Run("plan without committing", func(t *testing.T) {
+		fsys := memfs.FS{
+			"foo":       memfs.File("hello"),
+			"hello/bar": memfs.File("world"),
+		}
+		wd := watchdir.NewDirWatcher(fsys, watchdir.WithWriteStabilityThreshold(0))
+
+		// Plan and discard. The files are found again by the next plan.
+		cs, err := wd.Plan(context.Background())
+		require.NoError(t, err, "error planning")
+		require.Equal(t, []watchdir.Event{
+			{Type: watchdir.FileAdded, File: "foo", Sweep: 1},
+			{Type: watchdir.FileAdded, File: "hello/bar", Sweep: 1},
+		}, cs.Events(), "wrong planned events")
+		cs.Discard()
+		require.ErrorIs(t, cs.Commit(), watchdir.ErrStaleChangeSet, "should not commit a discarded change set")
+
+		// Plan and commit. Nothing is left for the next sweep.
+		cs, err = wd.Plan(context.Background())
+		require.NoError(t, err, "error planning")
+		require.Len(t, cs.Events(), 2, "wrong number of planned events")
+		require.NoError(t, cs.Commit(), "error committing")
+		events, err := sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.Empty(t, events, "should find no changes after commit")
+
+		// A change set planned before another commit is stale
+		delete(fsys, "foo")
+		stale, err := wd.Plan(context.Background())
+		require.NoError(t, err, "error planning")
+		events, err = sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.ElementsMatch(t, []string{"foo"}, events[watchdir.FileRemoved], "wrong files removed")
+		require.ErrorIs(t, stale.Commit(), watchdir.ErrStaleChangeSet, "should not commit a stale change set")
+	})
+	t.Run("commit individual paths", func(t *testing.T) {
+		fsys := memfs.FS{
+			"foo":       memfs.File("hello"),
+			"hello/bar": memfs.File("world"),
+			"hello/baz": memfs.File("golang"),
+		}
+		wd := watchdir.NewDirWatcher(fsys, watchdir.WithWriteStabilityThreshold(0))
+
+		// Commit only some of the files. The others are found again by the next sweep.
+		cs, err := wd.Plan(context.Background())
+		require.NoError(t, err, "error planning")
+		require.NoError(t, cs.CommitPaths("foo", "hello/bar"), "error committing paths")
+		events, err := sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.ElementsMatch(t, []string{"hello/baz"}, events[watchdir.FileAdded], "wrong files added")
+
+		// Removals can be committed individually too
+		delete(fsys, "foo")
+		delete(fsys, "hello/bar")
+		cs, err = wd.Plan(context.Background())
+		require.NoError(t, err, "error planning")
+		require.NoError(t, cs.CommitPaths("hello/bar"), "error committing paths")
+		events, err = sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.ElementsMatch(t, []string{"foo"}, events[watchdir.FileRemoved], "wrong files removed")
+		require.Empty(t, events[watchdir.FileAdded], "should find no added files")
+	})
+	t.Run("stable across consecutive sweeps", func(t *testing.T) {
+		dir := t.TempDir()
+		name := filepath.Join(dir, "foo")
+		writeFile(t, name, "hello")
+
+		// The modification time is far in the future, as it would be from a server with a skewed clock
+		future := time.Now().Add(time.Hour)
+		require.NoError(t, os.Chtimes(name, future, future), "error setting modification time")
+		wd := watchdir.New(os.DirFS(dir), watchdir.WithStableObservations(2))
+		events, err := sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.Empty(t, events, "should not report a file seen once")
+
+		// Changing the file starts the count again
+		writeFile(t, name, "hello world")
+		require.NoError(t, os.Chtimes(name, future, future), "error setting modification time")
+		events, err = sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.Empty(t, events, "should not report a file that changed")
+		events, err = sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.ElementsMatch(t, []string{"foo"}, events[watchdir.FileAdded], "should report a stable file")
+	})
+	t.Run("silent sweeps record files before they are stable", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, "foo"), "hello")
+		wd := watchdir.New(os.DirFS(dir), watchdir.WithSilentBaseline(), watchdir.WithStableObservations(2))
+		for range 2 {
+			events, err := sweepAndCollectEvents(t, wd)
+			require.NoError(t, err, "error sweeping")
+			require.Empty(t, events, "should not report files from the baseline")
+		}
+
+		// Files added after the baseline still wait until they are stable
+		writeFile(t, filepath.Join(dir, "bar"), "world")
+		events, err := sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.Empty(t, events, "should not report a file seen once")
+		events, err = sweepAndCollectEvents(t, wd)
+		require.NoError(t, err, "error sweeping")
+		require.Equal(t, map[watchdir.EventType][]string{watchdir.FileAdded: {"bar"}}, events, "should report a stable file")
+	})
+	t.Run("baseline records files before they are stable", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, "foo"), "hello")
+		wd := watchdir.NewDirWatcher(os.DirFS(dir), watchdir.WithStableObservations(2))
+		require.NoError(t, wd.Baseline(context.Background()), "error baselining")
+		snap, err := wd.Snapshot(context.Background())
+		require.NoError(t, err, "error taking snapshot")
+		require.Equal(t, 1, snap.Len(), "baseline should record every file")
+	})
+	t.Run("counts observations across partial commits", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, "foo"), "hello")
+		writeFile(t, filepath.Join(dir, "bar"), "world")
+		wd := watchdir.NewDirWatcher(os.DirFS(dir), watchdir.WithStableObservations(2))
+		cs, err := wd.Plan(context.Background())
+		require.NoError(t, err, "error planning")
+		require.Empty(t, cs.Events(), "should not report files seen once")
+		require.NoError(t, cs.CommitPaths("foo"), "error committing paths")
+
+		cs, err = wd.Plan(context.Background())
+		require.NoError(t, err, "error planning")
+		var files []string
+		for _, event := range cs.Events() {
+			files = append(files, event.File)
+		}
+		require.Equal(t, []string{"bar", "foo"}, files, "should report files seen twice")
+		require.NoError(t, cs.Commit(), "error committing")
+	})
+	t.Run("new watchers expose their view of the directory", func(t *testing.T) {
+		require.Implements(t, (*watchdir.DirWatcher)(nil), watchdir.New(memfs.FS{}), "watcher should be a DirWatcher")
+	})
 }
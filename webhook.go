@@ -0,0 +1,281 @@
+package watchdir
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultWebhookBatchSize is the default maximum number of events sent in a single webhook request.
+	DefaultWebhookBatchSize = 100
+
+	// DefaultWebhookBatchInterval is the default maximum time an event waits for its batch to fill before it is sent.
+	DefaultWebhookBatchInterval = time.Second
+
+	// DefaultWebhookSpoolLimit is the default maximum number of batches waiting to be delivered.
+	DefaultWebhookSpoolLimit = 1000
+
+	// DefaultWebhookTimeout is the default maximum time for a single delivery attempt.
+	DefaultWebhookTimeout = 30 * time.Second
+
+	// WebhookSignatureHeader is the request header that holds the HMAC-SHA256 signature of the request body, in the form
+	// "sha256=<hex digest>".
+	WebhookSignatureHeader = "X-Watchdir-Signature"
+)
+
+// WebhookConfig configures a WebhookSink.
+type WebhookConfig struct {
+	// URL is the endpoint that batches of events are POSTed to.
+	URL string
+
+	// Secret is the key used to sign each request body. If it is empty, requests are not signed.
+	Secret []byte
+
+	// Client is the HTTP client used to send requests. Defaults to http.DefaultClient.
+	Client *http.Client
+
+	// Timeout is the maximum time for a single delivery attempt, after which it is retried, so that an endpoint that
+	// never responds can't stall delivery. It applies on top of any timeout set on the client. Defaults to
+	// DefaultWebhookTimeout.
+	Timeout time.Duration
+
+	// BatchSize is the maximum number of events sent in a single request. Defaults to DefaultWebhookBatchSize.
+	BatchSize int
+
+	// BatchInterval is the maximum time an event waits for its batch to fill before it is sent. Defaults to
+	// DefaultWebhookBatchInterval.
+	BatchInterval time.Duration
+
+	// MinBackoff is the time to wait after the first failed delivery. It doubles after each consecutive failure, up to
+	// MaxBackoff. They default to one second and one minute.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// MaxAttempts, if positive, is the number of consecutive failed deliveries after which Run gives up and returns an
+	// error, instead of retrying forever. Undelivered batches remain in the spool directory, if one is configured.
+	MaxAttempts int
+
+	// SpoolDir is a directory where batches are stored until they are delivered, so they survive restarts. If it is
+	// empty, batches are held in memory.
+	SpoolDir string
+
+	// SpoolLimit is the maximum number of batches waiting to be delivered. When it is reached, no more events are read
+	// until the oldest batch is delivered. Defaults to DefaultWebhookSpoolLimit.
+	SpoolLimit int
+
+	// Logger, if not nil, receives a line for each failed delivery.
+	Logger *log.Logger
+}
+
+// WebhookPayload is the JSON body of each webhook request.
+type WebhookPayload struct {
+	Events []Event `json:"events"`
+}
+
+// WebhookSink delivers events to an HTTP endpoint in batches. Batches are delivered one at a time in the order their
+// events were received, and failed deliveries are retried with exponential backoff until they succeed or MaxAttempts is
+// reached, so the endpoint should treat any 2xx response as an acknowledgement and expect occasional duplicates.
+type WebhookSink struct {
+	cfg   WebhookConfig
+	queue batchQueue
+}
+
+// NewWebhookSink creates a webhook sink. If a spool directory is configured, any batches left in it by a previous run
+// are delivered before new events.
+func NewWebhookSink(cfg WebhookConfig) (*WebhookSink, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("webhook url is required")
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultWebhookTimeout
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultWebhookBatchSize
+	}
+	if cfg.BatchInterval <= 0 {
+		cfg.BatchInterval = DefaultWebhookBatchInterval
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = time.Second
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = max(time.Minute, cfg.MinBackoff)
+	}
+	if cfg.SpoolLimit <= 0 {
+		cfg.SpoolLimit = DefaultWebhookSpoolLimit
+	}
+
+	var queue batchQueue = &memoryQueue{}
+	if cfg.SpoolDir != "" {
+		spool, err := openDiskQueue(cfg.SpoolDir)
+		if err != nil {
+			return nil, fmt.Errorf("open spool: %w", err)
+		}
+		queue = spool
+	}
+	return &WebhookSink{cfg: cfg, queue: queue}, nil
+}
+
+// Run reads events from the channel and delivers them until the channel is closed and all events are delivered, the
+// context is cancelled, or a batch has failed MaxAttempts times. Events that haven't been delivered when it returns
+// remain in the spool directory, if one is configured.
+func (s *WebhookSink) Run(ctx context.Context, chanEvents <-chan Event) error {
+	var batch []Event
+	var failures int
+	var retryAt time.Time
+
+	timerBatch := time.NewTimer(s.cfg.BatchInterval)
+	defer timerBatch.Stop()
+	timerRetry := time.NewTimer(0)
+	defer timerRetry.Stop()
+
+	for {
+		// Stop reading events while the queue is full, so the backlog is bounded
+		full := s.queue.Len() >= s.cfg.SpoolLimit
+		chanIn := chanEvents
+		var chanBatch, chanRetry <-chan time.Time
+		if full {
+			chanIn = nil
+		} else if len(batch) > 0 {
+			chanBatch = timerBatch.C
+		}
+		if s.queue.Len() > 0 {
+			timerRetry.Reset(time.Until(retryAt))
+			chanRetry = timerRetry.C
+		}
+
+		// If the channel is closed and everything has been delivered, we're done
+		if chanEvents == nil && len(batch) == 0 && s.queue.Len() == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			// Keep the partial batch, so it is delivered by the next run if the queue is on disk
+			if len(batch) > 0 {
+				_ = s.queue.Push(batch)
+			}
+			return ctx.Err()
+		case event, ok := <-chanIn:
+			if !ok {
+				// Queue the final partial batch, then keep going until the queue is empty
+				chanEvents = nil
+				if len(batch) > 0 {
+					if err := s.queue.Push(batch); err != nil {
+						return fmt.Errorf("queue batch: %w", err)
+					}
+					batch = nil
+				}
+				continue
+			}
+			if len(batch) == 0 {
+				timerBatch.Reset(s.cfg.BatchInterval)
+			}
+			batch = append(batch, event)
+			if len(batch) >= s.cfg.BatchSize {
+				if err := s.queue.Push(batch); err != nil {
+					return fmt.Errorf("queue batch: %w", err)
+				}
+				batch = nil
+			}
+		case <-chanBatch:
+			if err := s.queue.Push(batch); err != nil {
+				return fmt.Errorf("queue batch: %w", err)
+			}
+			batch = nil
+		case <-chanRetry:
+			// Deliver the oldest batch, backing off if it fails
+			err := s.deliverNext(ctx)
+			if err == nil {
+				failures = 0
+				retryAt = time.Time{}
+				continue
+			}
+			if ctx.Err() != nil {
+				continue
+			}
+			failures++
+			if s.cfg.MaxAttempts > 0 && failures >= s.cfg.MaxAttempts {
+				if len(batch) > 0 {
+					_ = s.queue.Push(batch)
+				}
+				return fmt.Errorf("webhook delivery failed %d times: %w", failures, err)
+			}
+			backoff := min(s.cfg.MinBackoff<<min(failures-1, 30), s.cfg.MaxBackoff)
+			retryAt = time.Now().Add(backoff)
+			if s.cfg.Logger != nil {
+				s.cfg.Logger.Printf("webhook delivery failed %d times, retrying in %s: %v", failures, backoff, err)
+			}
+		}
+	}
+}
+
+// deliverNext sends the oldest batch in the queue, and removes it from the queue if it was delivered. A batch that
+// can't be decoded is skipped.
+func (s *WebhookSink) deliverNext(ctx context.Context) error {
+	batch, err := s.queue.Peek()
+	if errors.Is(err, errBadBatch) {
+		// The batch has been moved out of the queue, so carry on with the next one
+		if s.cfg.Logger != nil {
+			s.cfg.Logger.Printf("webhook batch skipped: %v", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.post(ctx, batch); err != nil {
+		return err
+	}
+	return s.queue.Pop()
+}
+
+// post sends a single batch to the endpoint.
+func (s *WebhookSink) post(ctx context.Context, batch []Event) error {
+	body, err := json.Marshal(WebhookPayload{Events: batch})
+	if err != nil {
+		return fmt.Errorf("encode payload: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(s.cfg.Secret) > 0 {
+		req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(s.cfg.Secret, body))
+	}
+
+	res, err := s.cfg.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("send request: %w", err)
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status: %s", res.Status)
+	}
+	return nil
+}
+
+// SignWebhookPayload returns the signature of a request body, in the form sent in the WebhookSignatureHeader header.
+// Receivers can compare it to the header using hmac.Equal to verify that a request was sent by the sink.
+func SignWebhookPayload(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,225 @@
+package watchdir_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spiretechnology/go-watchdir/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// webhookReceiver is a test endpoint that records the events it receives, and fails requests on demand.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	secret   []byte
+	failures int
+	files    []string
+	requests int
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests++
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(r.secret) > 0 {
+		expected := watchdir.SignWebhookPayload(r.secret, body)
+		if !hmac.Equal([]byte(expected), []byte(req.Header.Get(watchdir.WebhookSignatureHeader))) {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+	}
+	if r.failures > 0 {
+		r.failures--
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	var payload watchdir.WebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, event := range payload.Events {
+		r.files = append(r.files, event.File)
+	}
+}
+
+func (r *webhookReceiver) received() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.files...)
+}
+
+func sendEvents(n int) <-chan watchdir.Event {
+	chanEvents := make(chan watchdir.Event, n)
+	for i := range n {
+		chanEvents <- watchdir.Event{Type: watchdir.FileAdded, File: fmt.Sprintf("file%02d", i)}
+	}
+	close(chanEvents)
+	return chanEvents
+}
+
+func expectedFiles(n int) []string {
+	var files []string
+	for i := range n {
+		files = append(files, fmt.Sprintf("file%02d", i))
+	}
+	return files
+}
+
+func TestWebhookSink(t *testing.T) {
+	t.Run("delivers signed batches in order with retries", func(t *testing.T) {
+		receiver := &webhookReceiver{secret: []byte("secret"), failures: 3}
+		server := httptest.NewServer(receiver)
+		defer server.Close()
+
+		sink, err := watchdir.NewWebhookSink(watchdir.WebhookConfig{
+			URL:        server.URL,
+			Secret:     []byte("secret"),
+			BatchSize:  4,
+			MinBackoff: time.Millisecond,
+			MaxBackoff: 10 * time.Millisecond,
+		})
+		require.NoError(t, err, "error creating sink")
+
+		require.NoError(t, sink.Run(context.Background(), sendEvents(10)), "error running sink")
+		require.Equal(t, expectedFiles(10), receiver.received(), "wrong events received")
+		require.Equal(t, 6, receiver.requests, "wrong number of requests")
+	})
+	t.Run("retries requests that time out", func(t *testing.T) {
+		// The first request hangs until the test ends, long after the sink gives up on it
+		receiver := &webhookReceiver{}
+		release := make(chan struct{})
+		var hung sync.Once
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			hang := false
+			hung.Do(func() { hang = true })
+			if hang {
+				<-release
+				return
+			}
+			receiver.ServeHTTP(w, req)
+		}))
+		defer server.Close()
+		defer close(release)
+
+		sink, err := watchdir.NewWebhookSink(watchdir.WebhookConfig{
+			URL:        server.URL,
+			Timeout:    50 * time.Millisecond,
+			MinBackoff: time.Millisecond,
+		})
+		require.NoError(t, err, "error creating sink")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		require.NoError(t, sink.Run(ctx, sendEvents(3)), "error running sink")
+		require.Equal(t, expectedFiles(3), receiver.received(), "wrong events received")
+	})
+	t.Run("gives up after the maximum number of attempts", func(t *testing.T) {
+		receiver := &webhookReceiver{failures: 100}
+		server := httptest.NewServer(receiver)
+		defer server.Close()
+
+		sink, err := watchdir.NewWebhookSink(watchdir.WebhookConfig{
+			URL:         server.URL,
+			MinBackoff:  time.Millisecond,
+			MaxAttempts: 3,
+		})
+		require.NoError(t, err, "error creating sink")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		require.ErrorContains(t, sink.Run(ctx, sendEvents(3)), "503", "delivery should fail")
+		require.NoError(t, ctx.Err(), "the sink should give up before the context is cancelled")
+		require.Equal(t, 3, receiver.requests, "wrong number of requests")
+	})
+	t.Run("rejects unsigned requests", func(t *testing.T) {
+		receiver := &webhookReceiver{secret: []byte("secret")}
+		server := httptest.NewServer(receiver)
+		defer server.Close()
+
+		sink, err := watchdir.NewWebhookSink(watchdir.WebhookConfig{
+			URL:        server.URL,
+			Secret:     []byte("wrong"),
+			MinBackoff: time.Millisecond,
+		})
+		require.NoError(t, err, "error creating sink")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, sink.Run(ctx, sendEvents(1)), context.DeadlineExceeded)
+		require.Empty(t, receiver.received(), "no events should be received")
+	})
+	t.Run("spools batches to disk while the endpoint is down", func(t *testing.T) {
+		spoolDir := t.TempDir()
+		down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}))
+
+		// The endpoint is down, so the events are left in the spool
+		sink, err := watchdir.NewWebhookSink(watchdir.WebhookConfig{
+			URL:        down.URL,
+			BatchSize:  2,
+			MinBackoff: time.Millisecond,
+			MaxBackoff: time.Millisecond,
+			SpoolDir:   spoolDir,
+		})
+		require.NoError(t, err, "error creating sink")
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, sink.Run(ctx, sendEvents(5)), context.DeadlineExceeded)
+		down.Close()
+
+		// Once the endpoint recovers, a new sink delivers the spooled events before any new ones
+		receiver := &webhookReceiver{}
+		server := httptest.NewServer(receiver)
+		defer server.Close()
+		sink, err = watchdir.NewWebhookSink(watchdir.WebhookConfig{
+			URL:      server.URL,
+			SpoolDir: spoolDir,
+		})
+		require.NoError(t, err, "error creating sink")
+		chanEvents := make(chan watchdir.Event, 1)
+		chanEvents <- watchdir.Event{Type: watchdir.FileAdded, File: "later"}
+		close(chanEvents)
+		require.NoError(t, sink.Run(context.Background(), chanEvents), "error running sink")
+		require.Equal(t, append(expectedFiles(5), "later"), receiver.received(), "wrong events received")
+	})
+	t.Run("skips spooled batches that can't be decoded", func(t *testing.T) {
+		spoolDir := t.TempDir()
+		writeFile(t, filepath.Join(spoolDir, "00000000000000000000.json"), "not json")
+		writeFile(t, filepath.Join(spoolDir, "00000000000000000001.json"), `[{"type":"added","file":"file00"}]`)
+		writeFile(t, filepath.Join(spoolDir, "00000000000000000002.json.tmp"), `[{"type":"added","fi`)
+
+		receiver := &webhookReceiver{}
+		server := httptest.NewServer(receiver)
+		defer server.Close()
+		sink, err := watchdir.NewWebhookSink(watchdir.WebhookConfig{
+			URL:      server.URL,
+			SpoolDir: spoolDir,
+		})
+		require.NoError(t, err, "error creating sink")
+		require.NoFileExists(t, filepath.Join(spoolDir, "00000000000000000002.json.tmp"), "partial batch should be removed")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		require.NoError(t, sink.Run(ctx, sendEvents(0)), "error running sink")
+		require.Equal(t, expectedFiles(1), receiver.received(), "wrong events received")
+		require.Equal(t, []string{"00000000000000000000.json.bad"}, listFiles(t, spoolDir), "bad batch should be moved aside")
+	})
+}